@@ -14,10 +14,15 @@ var (
 		Use:   "geolocation",
 		Short: "Run micro service for geolocation",
 	}
+
+	cfgFile string
 )
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	// every command reads the same config as the server, e.g. the admin
+	// credentials used by the db commands
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", ".env", "config file (default is $HOME/.env)")
 }
 
 func Execute() {
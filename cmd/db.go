@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
+	"github.com/thiagozs/geolocation-go/server"
+)
+
+var (
+	dbServerURL string
+	dbEdition   string
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the databases of a running server through its admin routes",
+}
+
+var dbGenerationsCmd = &cobra.Command{
+	Use:   "generations",
+	Short: "List the kept database generations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var body struct {
+			Data []utils.Generation `json:"data"`
+		}
+		if err := adminRequest(http.MethodGet, "/admin/db/generations", &body); err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tEDITION\tBUILT\tINSTALLED\tSIZE\tCURRENT")
+		for _, g := range body.Data {
+			current := ""
+			if g.Current {
+				current = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", g.ID, g.EditionID,
+				g.BuildTime.Format(time.DateOnly), g.InstalledAt.Format(time.RFC3339), g.Size, current)
+		}
+		return w.Flush()
+	},
+}
+
+var dbRollbackCmd = &cobra.Command{
+	Use:   "rollback <generation>",
+	Short: "Reinstall a kept database generation and reload it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query := url.Values{"generation": {args[0]}}
+		if dbEdition != "" {
+			query.Set("edition", dbEdition)
+		}
+
+		var body struct {
+			Data utils.Generation `json:"data"`
+		}
+		if err := adminRequest(http.MethodPost, "/admin/db/rollback?"+query.Encode(), &body); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "rolled back %s to %s\n", body.Data.EditionID, body.Data.ID)
+		return nil
+	},
+}
+
+func init() {
+	dbCmd.PersistentFlags().StringVar(&dbServerURL, "server", "http://localhost:5000", "base URL of the server")
+	dbRollbackCmd.Flags().StringVar(&dbEdition, "edition", "", "edition to roll back (default primary)")
+	dbCmd.AddCommand(dbGenerationsCmd, dbRollbackCmd)
+	rootCmd.AddCommand(dbCmd)
+}
+
+// adminRequest calls an admin route with ADMIN_TOKEN, or signs the request
+// with ADMIN_HMAC_SECRET when no token is set, and decodes the JSON answer.
+func adminRequest(method, requestURI string, out interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimRight(dbServerURL, "/")+requestURI, nil)
+	if err != nil {
+		return err
+	}
+
+	if token := strings.TrimSpace(viper.GetString("ADMIN_TOKEN")); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if secret := strings.TrimSpace(viper.GetString("ADMIN_HMAC_SECRET")); secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(server.HeaderSignatureTimestamp, timestamp)
+		req.Header.Set(server.HeaderSignature, server.AdminSignature(secret, timestamp, method, requestURI, nil))
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: neither ADMIN_TOKEN nor ADMIN_HMAC_SECRET is set")
+	}
+
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, failure.Error)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return json.Unmarshal(data, out)
+}
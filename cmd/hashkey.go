@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/thiagozs/geolocation-go/server"
+)
+
+var hashkeyCmd = &cobra.Command{
+	Use:   "hashkey <api-key>",
+	Short: "Print the hash to store in the API keys file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println(server.HashAPIKey(args[0]))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hashkeyCmd)
+}
@@ -18,22 +18,43 @@ var runserverCmd = &cobra.Command{
 	Run:   runserver,
 }
 
-var (
-	cfgFile  string
-	httpPort int
-)
+var httpPort int
 
 func init() {
-	runserverCmd.PersistentFlags().StringVar(&cfgFile, "config", ".env", "config file (default is $HOME/.env)")
 	runserverCmd.PersistentFlags().IntVar(&httpPort, "http", 5000, "port for http server")
 	rootCmd.AddCommand(runserverCmd)
 }
 
 func runserver(cmd *cobra.Command, args []string) {
 	serverCfg := server.Config{
-		HTTPPort: httpPort,
-		Mode:     resolveMode(),
-		GeoIP:    buildMaxMindConfig(),
+		HTTPPort:        httpPort,
+		Mode:            resolveMode(),
+		MaxBatchSize:    viper.GetInt("MAX_BATCH_SIZE"),
+		FallbackLocales: splitList(viper.GetString("LOCALE_FALLBACK")),
+		TrustedProxies:  splitList(viper.GetString("TRUSTED_PROXIES")),
+		Scheduler:       buildSchedulerConfig(),
+		Admin: server.AdminConfig{
+			Token:               strings.TrimSpace(viper.GetString("ADMIN_TOKEN")),
+			HMACSecret:          strings.TrimSpace(viper.GetString("ADMIN_HMAC_SECRET")),
+			MaxSkew:             readDuration("ADMIN_SIGNATURE_MAX_SKEW"),
+			DisableLegacyUpdate: viper.GetBool("DISABLE_LEGACY_UPDATEDB"),
+		},
+		APIKeysPath: strings.TrimSpace(viper.GetString("API_KEYS_PATH")),
+		LookupRateLimit: server.RateLimitConfig{
+			PerIP:    viper.GetFloat64("RATE_LIMIT_IP"),
+			IPBurst:  viper.GetInt("RATE_LIMIT_IP_BURST"),
+			PerKey:   viper.GetFloat64("RATE_LIMIT_KEY"),
+			KeyBurst: viper.GetInt("RATE_LIMIT_KEY_BURST"),
+		},
+		AdminRateLimit: server.RateLimitConfig{
+			PerIP:   viper.GetFloat64("ADMIN_RATE_LIMIT_IP"),
+			IPBurst: viper.GetInt("ADMIN_RATE_LIMIT_IP_BURST"),
+		},
+		Providers:  splitList(viper.GetString("GEOIP_PROVIDER")),
+		GeoIP:      buildMaxMindConfig(),
+		MMDB:       buildMMDBConfig(),
+		MMDBPaths:  parsePairs(viper.GetString("MMDB_PATHS")),
+		MMDBFields: parseScopedFields(viper.GetString("MMDB_FIELDS")),
 	}
 
 	srv, err := server.NewServer(serverCfg)
@@ -59,8 +80,18 @@ func resolveMode() string {
 
 func buildMaxMindConfig() services.MaxMindConfig {
 	cfg := services.MaxMindConfig{
-		DatabasePath: strings.TrimSpace(viper.GetString("MAXMIND_DB_PATH")),
-		LicenseKey:   strings.TrimSpace(viper.GetString("MAXMIND_KEY")),
+		EditionID:       strings.TrimSpace(viper.GetString("MAXMIND_EDITION")),
+		DatabasePath:    strings.TrimSpace(viper.GetString("MAXMIND_DB_PATH")),
+		ASNDatabasePath: strings.TrimSpace(viper.GetString("MAXMIND_ASN_DB_PATH")),
+		Editions:        parseEditions(viper.GetString("MAXMIND_EDITIONS")),
+		OverlayPath:     strings.TrimSpace(viper.GetString("OVERLAY_PATH")),
+		OverlayInterval: readDuration("OVERLAY_RELOAD_INTERVAL"),
+		CacheSize:       viper.GetInt("LOOKUP_CACHE_SIZE"),
+		CacheShards:     viper.GetInt("LOOKUP_CACHE_SHARDS"),
+		LicenseKey:      strings.TrimSpace(viper.GetString("MAXMIND_KEY")),
+		KeepGenerations: viper.GetInt("MAXMIND_KEEP_GENERATIONS"),
+		WatchDatabase:   viper.GetBool("MAXMIND_WATCH"),
+		WatchDebounce:   readDuration("MAXMIND_WATCH_DEBOUNCE"),
 	}
 
 	if timeout := readDuration("MAXMIND_HTTP_TIMEOUT"); timeout > 0 {
@@ -74,6 +105,98 @@ func buildMaxMindConfig() services.MaxMindConfig {
 	return cfg
 }
 
+func buildMMDBConfig() services.MMDBConfig {
+	return services.MMDBConfig{
+		DatabasePath: strings.TrimSpace(viper.GetString("MMDB_PATH")),
+		Fields:       parseSharedFields(viper.GetString("MMDB_FIELDS")),
+		Language:     strings.TrimSpace(viper.GetString("MMDB_LANGUAGE")),
+	}
+}
+
+// parsePairs reads a comma separated list of key=value pairs, e.g.
+// "city_names=city,latitude=lat" or "dbip=/data/dbip.mmdb".
+func parsePairs(value string) map[string]string {
+	fields := map[string]string{}
+	for _, item := range splitList(value) {
+		field, path, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Printf("invalid key=path pair: %s", item)
+			continue
+		}
+		fields[strings.TrimSpace(field)] = strings.TrimSpace(path)
+	}
+	return fields
+}
+
+// parseSharedFields returns the MMDB_FIELDS entries that apply to every
+// mmdb-backed provider, e.g. "city_names=city".
+func parseSharedFields(value string) map[string]string {
+	shared := map[string]string{}
+	for field, path := range parsePairs(value) {
+		if !strings.Contains(field, ".") {
+			shared[field] = path
+		}
+	}
+	return shared
+}
+
+// parseScopedFields returns the MMDB_FIELDS entries scoped to one provider,
+// written as provider.field=path, e.g. "ipinfo.city_names=city". Field names
+// hold no dots, so the first one separates the provider.
+func parseScopedFields(value string) map[string]map[string]string {
+	scoped := map[string]map[string]string{}
+	for key, path := range parsePairs(value) {
+		provider, field, ok := strings.Cut(key, ".")
+		if !ok {
+			continue
+		}
+		provider = strings.ToLower(provider)
+		if scoped[provider] == nil {
+			scoped[provider] = map[string]string{}
+		}
+		scoped[provider][field] = path
+	}
+	return scoped
+}
+
+// parseEditions reads a comma separated list of edition IDs, each optionally
+// followed by =path, e.g. "GeoLite2-ASN=db/asn.mmdb,GeoLite2-Country".
+func parseEditions(value string) []services.EditionConfig {
+	var editions []services.EditionConfig
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		id, path, _ := strings.Cut(item, "=")
+		editions = append(editions, services.EditionConfig{
+			EditionID:    strings.TrimSpace(id),
+			DatabasePath: strings.TrimSpace(path),
+		})
+	}
+	return editions
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func buildSchedulerConfig() server.SchedulerConfig {
+	return server.SchedulerConfig{
+		Interval:     readDuration("MAXMIND_UPDATE_INTERVAL"),
+		Jitter:       readDuration("MAXMIND_UPDATE_JITTER"),
+		RetryBackoff: readDuration("MAXMIND_UPDATE_RETRY_BACKOFF"),
+		MaxBackoff:   readDuration("MAXMIND_UPDATE_MAX_BACKOFF"),
+	}
+}
+
 func readDuration(key string) time.Duration {
 	value := strings.TrimSpace(viper.GetString(key))
 	if value == "" {
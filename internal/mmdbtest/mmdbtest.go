@@ -0,0 +1,295 @@
+// Package mmdbtest builds small MaxMind DB files for tests. It only supports
+// what the test suites need: IPv6 trees with 24-bit records and data maps made
+// of strings, booleans, unsigned integers, doubles, maps and arrays.
+package mmdbtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+)
+
+const (
+	recordSize    = 24
+	separatorSize = 16
+)
+
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+type Network struct {
+	CIDR string
+	Data map[string]interface{}
+}
+
+type Options struct {
+	DatabaseType string
+	BuildEpoch   uint64
+	Languages    []string
+	Description  string
+}
+
+// Write builds a database from networks and stores it at path.
+func Write(path string, opts Options, networks []Network) error {
+	data, err := Build(opts, networks)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Build returns the raw bytes of a database containing networks. Networks are
+// inserted in order, so a more specific network must come after the one that
+// contains it.
+func Build(opts Options, networks []Network) ([]byte, error) {
+	if opts.DatabaseType == "" {
+		opts.DatabaseType = "GeoLite2-City"
+	}
+	if opts.Description == "" {
+		opts.Description = "test database"
+	}
+	if opts.Languages == nil {
+		opts.Languages = []string{"en"}
+	}
+
+	t := &tree{nodes: []node{{}}}
+	var dataSection bytes.Buffer
+
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.CIDR)
+		if err != nil {
+			return nil, err
+		}
+
+		offset := dataSection.Len()
+		if err := encode(&dataSection, network.Data); err != nil {
+			return nil, fmt.Errorf("encode %s: %w", network.CIDR, err)
+		}
+
+		// IPv4 networks live in the ::/96 subtree, which is where the
+		// reader starts IPv4 lookups in an IPv6 database
+		ip := make(net.IP, net.IPv6len)
+		ones, bits := ipNet.Mask.Size()
+		if bits == 32 {
+			copy(ip[12:], ipNet.IP.To4())
+			ones += 96
+		} else {
+			copy(ip, ipNet.IP)
+		}
+		t.insert(ip, ones, record{kind: recordData, value: offset})
+	}
+
+	nodeCount := len(t.nodes)
+	var out bytes.Buffer
+
+	for _, n := range t.nodes {
+		for _, r := range n {
+			writeUint24(&out, r.resolve(nodeCount))
+		}
+	}
+
+	out.Write(make([]byte, separatorSize))
+	out.Write(dataSection.Bytes())
+	out.Write(metadataStartMarker)
+
+	languages := make([]interface{}, 0, len(opts.Languages))
+	for _, lang := range opts.Languages {
+		languages = append(languages, lang)
+	}
+
+	metadata := map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 opts.BuildEpoch,
+		"database_type":               opts.DatabaseType,
+		"description":                 map[string]interface{}{"en": opts.Description},
+		"ip_version":                  uint16(6),
+		"languages":                   languages,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	}
+	if err := encode(&out, metadata); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+type recordKind int
+
+const (
+	recordEmpty recordKind = iota
+	recordNode
+	recordData
+)
+
+type record struct {
+	kind  recordKind
+	value int
+}
+
+func (r record) resolve(nodeCount int) int {
+	switch r.kind {
+	case recordNode:
+		return r.value
+	case recordData:
+		return nodeCount + separatorSize + r.value
+	default:
+		return nodeCount
+	}
+}
+
+type node [2]record
+
+type tree struct {
+	nodes []node
+}
+
+func (t *tree) insert(ip net.IP, prefixLen int, leaf record) {
+	current := 0
+	for depth := 0; depth < prefixLen; depth++ {
+		bit := (ip[depth/8] >> (7 - uint(depth%8))) & 1
+
+		if depth == prefixLen-1 {
+			t.nodes[current][bit] = leaf
+			return
+		}
+
+		next := t.nodes[current][bit]
+		if next.kind != recordNode {
+			// split an empty or data record so the more specific network can
+			// be placed below it while the rest keeps the previous value
+			t.nodes = append(t.nodes, node{next, next})
+			next = record{kind: recordNode, value: len(t.nodes) - 1}
+			t.nodes[current][bit] = next
+		}
+		current = next.value
+	}
+}
+
+func writeUint24(buf *bytes.Buffer, value int) {
+	buf.WriteByte(byte(value >> 16))
+	buf.WriteByte(byte(value >> 8))
+	buf.WriteByte(byte(value))
+}
+
+const (
+	typeString = 2
+	typeDouble = 3
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+	typeBool   = 14
+)
+
+func encode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		writeControl(buf, typeBool, size)
+	case float64:
+		writeControl(buf, typeDouble, 8)
+		var raw [8]byte
+		binary.BigEndian.PutUint64(raw[:], math.Float64bits(v))
+		buf.Write(raw[:])
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case int:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint:
+		writeUint(buf, typeUint64, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case map[string]string:
+		converted := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			converted[key] = val
+		}
+		return encode(buf, converted)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		writeControl(buf, typeMap, len(keys))
+		for _, key := range keys {
+			if err := encode(buf, key); err != nil {
+				return err
+			}
+			if err := encode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	case []string:
+		writeControl(buf, typeArray, len(v))
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		writeControl(buf, typeArray, len(v))
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %T", value)
+	}
+	return nil
+}
+
+func writeUint(buf *bytes.Buffer, typeNum int, value uint64) {
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], value)
+	trimmed := bytes.TrimLeft(raw[:], "\x00")
+	writeControl(buf, typeNum, len(trimmed))
+	buf.Write(trimmed)
+}
+
+func writeControl(buf *bytes.Buffer, typeNum, size int) {
+	var control byte
+	extended := typeNum > 7
+	if !extended {
+		control = byte(typeNum << 5)
+	}
+
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+		control |= byte(size)
+	case size < 285:
+		control |= 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 65821:
+		control |= 30
+		rest := size - 285
+		sizeBytes = []byte{byte(rest >> 8), byte(rest)}
+	default:
+		control |= 31
+		rest := size - 65821
+		sizeBytes = []byte{byte(rest >> 16), byte(rest >> 8), byte(rest)}
+	}
+
+	buf.WriteByte(control)
+	if extended {
+		buf.WriteByte(byte(typeNum - 7))
+	}
+	buf.Write(sizeBytes)
+}
@@ -0,0 +1,13 @@
+package models
+
+type BatchError struct {
+	Code           string `json:"code"`
+	Message        string `json:"message"`
+	Classification string `json:"classification,omitempty"`
+}
+
+type BatchResult struct {
+	Address string      `json:"address"`
+	Data    *Record     `json:"data,omitempty"`
+	Error   *BatchError `json:"error,omitempty"`
+}
@@ -0,0 +1,102 @@
+package models
+
+// FullRecord decodes the complete GeoIP2/GeoLite2 City schema. Record stays
+// the compact shape returned by default.
+type FullRecord struct {
+	City struct {
+		Confidence uint8             `maxminddb:"confidence"`
+		GeoNameID  uint              `maxminddb:"geoname_id"`
+		Names      map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Code      string            `maxminddb:"code"`
+		GeoNameID uint              `maxminddb:"geoname_id"`
+		Names     map[string]string `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	Country            CountryRecord            `maxminddb:"country"`
+	RegisteredCountry  CountryRecord            `maxminddb:"registered_country"`
+	RepresentedCountry RepresentedCountryRecord `maxminddb:"represented_country"`
+	Location           struct {
+		AccuracyRadius uint16  `maxminddb:"accuracy_radius"`
+		Latitude       float64 `maxminddb:"latitude"`
+		Longitude      float64 `maxminddb:"longitude"`
+		MetroCode      uint    `maxminddb:"metro_code"`
+		TimeZone       string  `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code       string `maxminddb:"code"`
+		Confidence uint8  `maxminddb:"confidence"`
+	} `maxminddb:"postal"`
+	Subdivisions []SubdivisionRecord `maxminddb:"subdivisions"`
+	Traits       struct {
+		IsAnonymousProxy    bool `maxminddb:"is_anonymous_proxy"`
+		IsAnycast           bool `maxminddb:"is_anycast"`
+		IsSatelliteProvider bool `maxminddb:"is_satellite_provider"`
+	} `maxminddb:"traits"`
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number" json:"autonomous_system_number,omitempty"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization" json:"autonomous_system_organization,omitempty"`
+	IP                           string
+	Network                      string
+	PrefixLength                 int
+	Found                        bool
+	Classification               string
+	Metadata                     map[string]string
+	Sources                      map[string]string
+}
+
+// MapNames replaces every localized names map in the record with fn's result.
+func (r *FullRecord) MapNames(fn func(map[string]string) map[string]string) {
+	r.City.Names = fn(r.City.Names)
+	r.Continent.Names = fn(r.Continent.Names)
+	r.Country.Names = fn(r.Country.Names)
+	r.RegisteredCountry.Names = fn(r.RegisteredCountry.Names)
+	r.RepresentedCountry.Names = fn(r.RepresentedCountry.Names)
+	for i := range r.Subdivisions {
+		r.Subdivisions[i].Names = fn(r.Subdivisions[i].Names)
+	}
+}
+
+type CountryRecord struct {
+	Confidence        uint8             `maxminddb:"confidence"`
+	GeoNameID         uint              `maxminddb:"geoname_id"`
+	IsInEuropeanUnion bool              `maxminddb:"is_in_european_union"`
+	ISOCode           string            `maxminddb:"iso_code"`
+	Names             map[string]string `maxminddb:"names"`
+}
+
+type RepresentedCountryRecord struct {
+	GeoNameID         uint              `maxminddb:"geoname_id"`
+	IsInEuropeanUnion bool              `maxminddb:"is_in_european_union"`
+	ISOCode           string            `maxminddb:"iso_code"`
+	Names             map[string]string `maxminddb:"names"`
+	Type              string            `maxminddb:"type"`
+}
+
+type SubdivisionRecord struct {
+	Confidence uint8             `maxminddb:"confidence"`
+	GeoNameID  uint              `maxminddb:"geoname_id"`
+	ISOCode    string            `maxminddb:"iso_code"`
+	Names      map[string]string `maxminddb:"names"`
+}
+
+// Compact returns the fields of the record that make up a Record.
+func (r FullRecord) Compact() Record {
+	var record Record
+	record.Country.IsInEuropeanUnion = r.Country.IsInEuropeanUnion
+	record.Country.ISOCode = r.Country.ISOCode
+	record.City.Names = r.City.Names
+	record.Location = r.Location
+	record.Postal.Code = r.Postal.Code
+	record.Traits.IsAnonymousProxy = r.Traits.IsAnonymousProxy
+	record.Traits.IsSatelliteProvider = r.Traits.IsSatelliteProvider
+	record.AutonomousSystemNumber = r.AutonomousSystemNumber
+	record.AutonomousSystemOrganization = r.AutonomousSystemOrganization
+	record.IP = r.IP
+	record.Network = r.Network
+	record.PrefixLength = r.PrefixLength
+	record.Found = r.Found
+	record.Classification = r.Classification
+	record.Metadata = r.Metadata
+	record.Sources = r.Sources
+	return record
+}
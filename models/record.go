@@ -22,5 +22,18 @@ type Record struct {
 		IsAnonymousProxy    bool `maxminddb:"is_anonymous_proxy"`
 		IsSatelliteProvider bool `maxminddb:"is_satellite_provider"`
 	} `maxminddb:"traits"`
-	IP string
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number" json:"autonomous_system_number,omitempty"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization" json:"autonomous_system_organization,omitempty"`
+	IP                           string
+	Network                      string
+	PrefixLength                 int
+	Found                        bool
+	Classification               string
+	Metadata                     map[string]string
+	Sources                      map[string]string
+}
+
+// MapNames replaces every localized names map in the record with fn's result.
+func (r *Record) MapNames(fn func(map[string]string) map[string]string) {
+	r.City.Names = fn(r.City.Names)
 }
@@ -2,4 +2,6 @@ package models
 
 type Request struct {
 	Address string `form:"address" json:"address"`
+	Format  string `form:"format" json:"format"`
+	Lang    string `form:"lang" json:"lang"`
 }
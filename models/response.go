@@ -0,0 +1,200 @@
+package models
+
+// LookupResponse is the stable JSON shape served under /v1. It is decoupled
+// from Record and FullRecord so the mmdb decode structs can change without
+// breaking API clients. Fields only present in the full shape are omitted from
+// compact responses.
+type LookupResponse struct {
+	IP                 string                      `json:"ip"`
+	Network            string                      `json:"network,omitempty"`
+	PrefixLength       int                         `json:"prefix_length"`
+	Found              bool                        `json:"found"`
+	Classification     string                      `json:"classification,omitempty"`
+	Continent          *ContinentResponse          `json:"continent,omitempty"`
+	Country            CountryResponse             `json:"country"`
+	RegisteredCountry  *CountryResponse            `json:"registered_country,omitempty"`
+	RepresentedCountry *RepresentedCountryResponse `json:"represented_country,omitempty"`
+	Subdivisions       []SubdivisionResponse       `json:"subdivisions,omitempty"`
+	City               CityResponse                `json:"city"`
+	Location           LocationResponse            `json:"location"`
+	Postal             PostalResponse              `json:"postal"`
+	Traits             TraitsResponse              `json:"traits"`
+	ASN                *ASNResponse                `json:"asn,omitempty"`
+	Metadata           map[string]string           `json:"metadata,omitempty"`
+	Sources            map[string]string           `json:"sources,omitempty"`
+}
+
+type ContinentResponse struct {
+	Code      string            `json:"code,omitempty"`
+	GeoNameID uint              `json:"geoname_id,omitempty"`
+	Names     map[string]string `json:"names,omitempty"`
+}
+
+type CountryResponse struct {
+	Confidence        uint8             `json:"confidence,omitempty"`
+	GeoNameID         uint              `json:"geoname_id,omitempty"`
+	IsInEuropeanUnion bool              `json:"is_in_european_union"`
+	ISOCode           string            `json:"iso_code,omitempty"`
+	Names             map[string]string `json:"names,omitempty"`
+}
+
+type RepresentedCountryResponse struct {
+	CountryResponse
+	Type string `json:"type,omitempty"`
+}
+
+type SubdivisionResponse struct {
+	Confidence uint8             `json:"confidence,omitempty"`
+	GeoNameID  uint              `json:"geoname_id,omitempty"`
+	ISOCode    string            `json:"iso_code,omitempty"`
+	Names      map[string]string `json:"names,omitempty"`
+}
+
+type CityResponse struct {
+	Confidence uint8             `json:"confidence,omitempty"`
+	GeoNameID  uint              `json:"geoname_id,omitempty"`
+	Names      map[string]string `json:"names,omitempty"`
+}
+
+type LocationResponse struct {
+	AccuracyRadius uint16  `json:"accuracy_radius"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	MetroCode      uint    `json:"metro_code,omitempty"`
+	TimeZone       string  `json:"time_zone,omitempty"`
+}
+
+type PostalResponse struct {
+	Code       string `json:"code,omitempty"`
+	Confidence uint8  `json:"confidence,omitempty"`
+}
+
+type TraitsResponse struct {
+	IsAnonymousProxy    bool `json:"is_anonymous_proxy"`
+	IsAnycast           bool `json:"is_anycast"`
+	IsSatelliteProvider bool `json:"is_satellite_provider"`
+}
+
+type ASNResponse struct {
+	Number       uint   `json:"number"`
+	Organization string `json:"organization,omitempty"`
+}
+
+type BatchLookupResponse struct {
+	Address string          `json:"address"`
+	Data    *LookupResponse `json:"data,omitempty"`
+	Error   *BatchError     `json:"error,omitempty"`
+}
+
+func NewLookupResponse(record Record) LookupResponse {
+	return LookupResponse{
+		IP:             record.IP,
+		Network:        record.Network,
+		PrefixLength:   record.PrefixLength,
+		Found:          record.Found,
+		Classification: record.Classification,
+		Country: CountryResponse{
+			IsInEuropeanUnion: record.Country.IsInEuropeanUnion,
+			ISOCode:           record.Country.ISOCode,
+		},
+		City: CityResponse{
+			Names: record.City.Names,
+		},
+		Location: LocationResponse{
+			AccuracyRadius: record.Location.AccuracyRadius,
+			Latitude:       record.Location.Latitude,
+			Longitude:      record.Location.Longitude,
+			MetroCode:      record.Location.MetroCode,
+			TimeZone:       record.Location.TimeZone,
+		},
+		Postal: PostalResponse{
+			Code: record.Postal.Code,
+		},
+		Traits: TraitsResponse{
+			IsAnonymousProxy:    record.Traits.IsAnonymousProxy,
+			IsSatelliteProvider: record.Traits.IsSatelliteProvider,
+		},
+		ASN:      newASNResponse(record.AutonomousSystemNumber, record.AutonomousSystemOrganization),
+		Metadata: record.Metadata,
+		Sources:  record.Sources,
+	}
+}
+
+func NewFullLookupResponse(record FullRecord) LookupResponse {
+	registered := newCountryResponse(record.RegisteredCountry)
+
+	resp := LookupResponse{
+		IP:             record.IP,
+		Network:        record.Network,
+		PrefixLength:   record.PrefixLength,
+		Found:          record.Found,
+		Classification: record.Classification,
+		Continent: &ContinentResponse{
+			Code:      record.Continent.Code,
+			GeoNameID: record.Continent.GeoNameID,
+			Names:     record.Continent.Names,
+		},
+		Country:           newCountryResponse(record.Country),
+		RegisteredCountry: &registered,
+		RepresentedCountry: &RepresentedCountryResponse{
+			CountryResponse: CountryResponse{
+				GeoNameID:         record.RepresentedCountry.GeoNameID,
+				IsInEuropeanUnion: record.RepresentedCountry.IsInEuropeanUnion,
+				ISOCode:           record.RepresentedCountry.ISOCode,
+				Names:             record.RepresentedCountry.Names,
+			},
+			Type: record.RepresentedCountry.Type,
+		},
+		City: CityResponse{
+			Confidence: record.City.Confidence,
+			GeoNameID:  record.City.GeoNameID,
+			Names:      record.City.Names,
+		},
+		Location: LocationResponse{
+			AccuracyRadius: record.Location.AccuracyRadius,
+			Latitude:       record.Location.Latitude,
+			Longitude:      record.Location.Longitude,
+			MetroCode:      record.Location.MetroCode,
+			TimeZone:       record.Location.TimeZone,
+		},
+		Postal: PostalResponse{
+			Code:       record.Postal.Code,
+			Confidence: record.Postal.Confidence,
+		},
+		Traits: TraitsResponse{
+			IsAnonymousProxy:    record.Traits.IsAnonymousProxy,
+			IsAnycast:           record.Traits.IsAnycast,
+			IsSatelliteProvider: record.Traits.IsSatelliteProvider,
+		},
+		ASN:      newASNResponse(record.AutonomousSystemNumber, record.AutonomousSystemOrganization),
+		Metadata: record.Metadata,
+		Sources:  record.Sources,
+	}
+
+	for _, subdivision := range record.Subdivisions {
+		resp.Subdivisions = append(resp.Subdivisions, SubdivisionResponse{
+			Confidence: subdivision.Confidence,
+			GeoNameID:  subdivision.GeoNameID,
+			ISOCode:    subdivision.ISOCode,
+			Names:      subdivision.Names,
+		})
+	}
+	return resp
+}
+
+func newCountryResponse(country CountryRecord) CountryResponse {
+	return CountryResponse{
+		Confidence:        country.Confidence,
+		GeoNameID:         country.GeoNameID,
+		IsInEuropeanUnion: country.IsInEuropeanUnion,
+		ISOCode:           country.ISOCode,
+		Names:             country.Names,
+	}
+}
+
+func newASNResponse(number uint, organization string) *ASNResponse {
+	if number == 0 && organization == "" {
+		return nil
+	}
+	return &ASNResponse{Number: number, Organization: organization}
+}
@@ -0,0 +1,134 @@
+// Package metrics holds the Prometheus collectors of the service. They are
+// registered on the default registry, which the /metrics route serves.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "geolocation"
+
+// Lookup outcomes.
+const (
+	LookupFound    = "found"
+	LookupNotFound = "not_found"
+	LookupError    = "error"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	Lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lookups_total",
+		Help:      "Address lookups by outcome: found, not_found or error.",
+	}, []string{"outcome"})
+
+	UpdateAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "database_update_attempts_total",
+		Help:      "Database update checks by edition.",
+	}, []string{"edition"})
+
+	UpdateSuccesses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "database_update_successes_total",
+		Help:      "Database update checks that completed, whether or not a new file was installed.",
+	}, []string{"edition"})
+
+	UpdateFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "database_update_failures_total",
+		Help:      "Database update checks that failed.",
+	}, []string{"edition"})
+
+	DownloadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "database_download_bytes_total",
+		Help:      "Bytes of database archives downloaded.",
+	}, []string{"edition"})
+
+	DownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "database_download_duration_seconds",
+		Help:      "Time spent downloading and unpacking database archives.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"edition"})
+)
+
+var databases = newDatabaseCollector()
+
+func init() {
+	prometheus.MustRegister(databases)
+}
+
+// SetDatabaseBuild records the build time of the database currently loaded
+// for edition.
+func SetDatabaseBuild(edition string, built time.Time) {
+	databases.set(edition, built)
+}
+
+// databaseCollector reports the build epoch of each loaded database and its
+// age at scrape time.
+type databaseCollector struct {
+	mu     sync.Mutex
+	builds map[string]time.Time
+	now    func() time.Time
+
+	epoch *prometheus.Desc
+	age   *prometheus.Desc
+}
+
+func newDatabaseCollector() *databaseCollector {
+	return &databaseCollector{
+		builds: map[string]time.Time{},
+		now:    time.Now,
+		epoch: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "database", "build_epoch_seconds"),
+			"Build time of the loaded database as a unix timestamp.",
+			[]string{"edition"}, nil,
+		),
+		age: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "database", "age_seconds"),
+			"Seconds since the loaded database was built.",
+			[]string{"edition"}, nil,
+		),
+	}
+}
+
+func (d *databaseCollector) set(edition string, built time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.builds[edition] = built
+}
+
+func (d *databaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.epoch
+	ch <- d.age
+}
+
+func (d *databaseCollector) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for edition, built := range d.builds {
+		ch <- prometheus.MustNewConstMetric(d.epoch, prometheus.GaugeValue, float64(built.Unix()), edition)
+		ch <- prometheus.MustNewConstMetric(d.age, prometheus.GaugeValue, now.Sub(built).Seconds(), edition)
+	}
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDatabaseCollectorReportsEpochAndAge(t *testing.T) {
+	collector := newDatabaseCollector()
+	built := time.Unix(1700000000, 0)
+	collector.now = func() time.Time { return built.Add(90 * time.Minute) }
+	collector.set("GeoLite2-City", built)
+
+	expected := `
+# HELP geolocation_database_age_seconds Seconds since the loaded database was built.
+# TYPE geolocation_database_age_seconds gauge
+geolocation_database_age_seconds{edition="GeoLite2-City"} 5400
+# HELP geolocation_database_build_epoch_seconds Build time of the loaded database as a unix timestamp.
+# TYPE geolocation_database_build_epoch_seconds gauge
+geolocation_database_build_epoch_seconds{edition="GeoLite2-City"} 1.7e+09
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package utils
+
+import "net"
+
+// AddressClass is the special-purpose category an address belongs to,
+// following the IANA IPv4 and IPv6 special-purpose address registries and the
+// multicast ranges.
+type AddressClass string
+
+const (
+	AddressPublic        AddressClass = "public"
+	AddressPrivate       AddressClass = "private"
+	AddressLoopback      AddressClass = "loopback"
+	AddressLinkLocal     AddressClass = "link_local"
+	AddressCGNAT         AddressClass = "cgnat"
+	AddressDocumentation AddressClass = "documentation"
+	AddressMulticast     AddressClass = "multicast"
+	AddressUnspecified   AddressClass = "unspecified"
+	AddressThisNetwork   AddressClass = "this_network"
+	AddressBroadcast     AddressClass = "broadcast"
+	AddressBenchmarking  AddressClass = "benchmarking"
+	AddressProtocol      AddressClass = "protocol_assignment"
+	AddressTranslation   AddressClass = "translation"
+	AddressTunnel        AddressClass = "tunnel"
+	AddressDiscard       AddressClass = "discard"
+	AddressReserved      AddressClass = "reserved"
+)
+
+type specialRange struct {
+	network *net.IPNet
+	class   AddressClass
+	global  bool
+}
+
+// specialRanges is ordered so more specific ranges come before the ranges
+// that contain them.
+var specialRanges = buildSpecialRanges([]struct {
+	cidr   string
+	class  AddressClass
+	global bool
+}{
+	// IPv4
+	{"0.0.0.0/32", AddressUnspecified, false},
+	{"0.0.0.0/8", AddressThisNetwork, false},
+	{"10.0.0.0/8", AddressPrivate, false},
+	{"100.64.0.0/10", AddressCGNAT, false},
+	{"127.0.0.0/8", AddressLoopback, false},
+	{"169.254.0.0/16", AddressLinkLocal, false},
+	{"172.16.0.0/12", AddressPrivate, false},
+	{"192.0.0.9/32", AddressProtocol, true},
+	{"192.0.0.10/32", AddressProtocol, true},
+	{"192.0.0.0/24", AddressProtocol, false},
+	{"192.0.2.0/24", AddressDocumentation, false},
+	{"192.88.99.0/24", AddressReserved, false},
+	{"192.168.0.0/16", AddressPrivate, false},
+	{"198.18.0.0/15", AddressBenchmarking, false},
+	{"198.51.100.0/24", AddressDocumentation, false},
+	{"203.0.113.0/24", AddressDocumentation, false},
+	{"224.0.0.0/4", AddressMulticast, false},
+	{"255.255.255.255/32", AddressBroadcast, false},
+	{"240.0.0.0/4", AddressReserved, false},
+
+	// IPv6
+	{"::/128", AddressUnspecified, false},
+	{"::1/128", AddressLoopback, false},
+	{"64:ff9b::/96", AddressTranslation, true},
+	{"64:ff9b:1::/48", AddressTranslation, false},
+	{"100::/64", AddressDiscard, false},
+	{"2001::/32", AddressTunnel, true},
+	{"2001:1::1/128", AddressProtocol, true},
+	{"2001:1::2/128", AddressProtocol, true},
+	{"2001:1::3/128", AddressProtocol, true},
+	{"2001:3::/32", AddressProtocol, true},
+	{"2001:4:112::/48", AddressProtocol, true},
+	{"2001:20::/28", AddressProtocol, true},
+	{"2001:30::/28", AddressProtocol, true},
+	{"2001:2::/48", AddressBenchmarking, false},
+	{"2001:10::/28", AddressReserved, false},
+	{"2001::/23", AddressProtocol, false},
+	{"2001:db8::/32", AddressDocumentation, false},
+	{"2002::/16", AddressTunnel, true},
+	{"3fff::/20", AddressDocumentation, false},
+	{"5f00::/16", AddressReserved, false},
+	{"fc00::/7", AddressPrivate, false},
+	{"fe80::/10", AddressLinkLocal, false},
+	{"fec0::/10", AddressReserved, false},
+	{"ff00::/8", AddressMulticast, false},
+})
+
+func buildSpecialRanges(entries []struct {
+	cidr   string
+	class  AddressClass
+	global bool
+}) []specialRange {
+	ranges := make([]specialRange, 0, len(entries))
+	for _, entry := range entries {
+		_, network, err := net.ParseCIDR(entry.cidr)
+		if err != nil {
+			panic(err)
+		}
+		ranges = append(ranges, specialRange{network: network, class: entry.class, global: entry.global})
+	}
+	return ranges
+}
+
+// ClassifyIP returns the special-purpose class of ip, or AddressPublic when
+// it does not belong to any special-purpose range. IPv4-mapped IPv6 addresses
+// are classified as their IPv4 address.
+func ClassifyIP(ip net.IP) AddressClass {
+	class, _ := classify(ip)
+	return class
+}
+
+// IsGloballyReachable reports whether ip can appear as a public internet
+// address, i.e. whether a geolocation database may be expected to know it.
+func IsGloballyReachable(ip net.IP) bool {
+	_, global := classify(ip)
+	return global
+}
+
+func classify(ip net.IP) (AddressClass, bool) {
+	if ip == nil {
+		return AddressReserved, false
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	for _, r := range specialRanges {
+		if len(r.network.IP) == len(ip) && r.network.Contains(ip) {
+			return r.class, r.global
+		}
+	}
+	return AddressPublic, true
+}
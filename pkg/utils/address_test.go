@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClassifyIP(t *testing.T) {
+	tests := []struct {
+		address string
+		want    AddressClass
+		global  bool
+	}{
+		{"8.8.8.8", AddressPublic, true},
+		{"10.0.0.1", AddressPrivate, false},
+		{"172.31.255.255", AddressPrivate, false},
+		{"172.32.0.1", AddressPublic, true},
+		{"192.168.1.1", AddressPrivate, false},
+		{"100.64.1.1", AddressCGNAT, false},
+		{"100.128.0.1", AddressPublic, true},
+		{"127.0.0.1", AddressLoopback, false},
+		{"169.254.10.1", AddressLinkLocal, false},
+		{"0.0.0.0", AddressUnspecified, false},
+		{"0.1.2.3", AddressThisNetwork, false},
+		{"192.0.0.9", AddressProtocol, true},
+		{"192.0.0.1", AddressProtocol, false},
+		{"192.0.2.1", AddressDocumentation, false},
+		{"198.51.100.7", AddressDocumentation, false},
+		{"203.0.113.9", AddressDocumentation, false},
+		{"198.19.0.1", AddressBenchmarking, false},
+		{"224.0.0.251", AddressMulticast, false},
+		{"255.255.255.255", AddressBroadcast, false},
+		{"250.1.1.1", AddressReserved, false},
+		{"::ffff:10.1.1.1", AddressPrivate, false},
+		{"2606:4700:4700::1111", AddressPublic, true},
+		{"::", AddressUnspecified, false},
+		{"::1", AddressLoopback, false},
+		{"fd00::1", AddressPrivate, false},
+		{"fe80::1", AddressLinkLocal, false},
+		{"ff02::1", AddressMulticast, false},
+		{"2001:db8::1", AddressDocumentation, false},
+		{"3fff::1", AddressDocumentation, false},
+		{"2001:2::1", AddressBenchmarking, false},
+		{"2001::1", AddressTunnel, true},
+		{"2002:c000:204::1", AddressTunnel, true},
+		{"64:ff9b::808:808", AddressTranslation, true},
+		{"100::1", AddressDiscard, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			ip := net.ParseIP(tt.address)
+			if got := ClassifyIP(ip); got != tt.want {
+				t.Fatalf("ClassifyIP(%s) = %s, want %s", tt.address, got, tt.want)
+			}
+			if got := IsGloballyReachable(ip); got != tt.global {
+				t.Fatalf("IsGloballyReachable(%s) = %v, want %v", tt.address, got, tt.global)
+			}
+		})
+	}
+}
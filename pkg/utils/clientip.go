@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver derives the address of the client that originated a
+// request. Forwarding headers are only honored when the direct peer is one of
+// the trusted proxies, and the forwarding chain is walked from the nearest hop
+// outwards, skipping trusted proxies, so a client cannot spoof its address by
+// sending the headers itself.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	resolver := &ClientIPResolver{}
+	for _, cidr := range trustedProxies {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		resolver.trusted = append(resolver.trusted, network)
+	}
+	return resolver, nil
+}
+
+// Resolve returns the client address, or nil when RemoteAddr cannot be parsed.
+func (r *ClientIPResolver) Resolve(req *http.Request) net.IP {
+	remote := parseHostIP(req.RemoteAddr)
+	if remote == nil || !r.isTrusted(remote) {
+		return remote
+	}
+
+	hops := forwardedFor(req.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = xForwardedFor(req.Header.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 {
+		if realIP := parseHostIP(req.Header.Get("X-Real-IP")); realIP != nil {
+			return realIP
+		}
+		return remote
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop == nil {
+			// obfuscated or malformed entry, nothing beyond it can be trusted
+			break
+		}
+
+		client = hop
+		if !r.isTrusted(hop) {
+			break
+		}
+	}
+	return client
+}
+
+func (r *ClientIPResolver) isTrusted(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func xForwardedFor(values []string) []net.IP {
+	var hops []net.IP
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				hops = append(hops, parseHostIP(item))
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers.
+func forwardedFor(values []string) []net.IP {
+	var hops []net.IP
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "for") {
+					continue
+				}
+				hops = append(hops, parseHostIP(strings.Trim(strings.TrimSpace(val), `"`)))
+			}
+		}
+	}
+	return hops
+}
+
+// parseHostIP accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port".
+func parseHostIP(value string) net.IP {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	if ip := net.ParseIP(value); ip != nil {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return net.ParseIP(host)
+	}
+
+	return net.ParseIP(strings.Trim(value, "[]"))
+}
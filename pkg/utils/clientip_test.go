@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer ignores headers", "203.0.113.5:1234", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "203.0.113.5"},
+		{"trusted peer x-forwarded-for", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "1.1.1.1"},
+		{"spoofed leftmost entry is skipped", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.1.1.1, 10.0.0.2"}, "1.1.1.1"},
+		{"all hops trusted", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"single trusted proxy address", "192.0.2.10:80", map[string]string{"X-Forwarded-For": "8.8.8.8"}, "8.8.8.8"},
+		{"x-real-ip", "10.0.0.1:80", map[string]string{"X-Real-IP": "1.1.1.1"}, "1.1.1.1"},
+		{"forwarded wins", "10.0.0.1:80", map[string]string{
+			"Forwarded":       `for="[2001:db8::17]:4711";proto=https, for=10.0.0.5`,
+			"X-Forwarded-For": "9.9.9.9",
+		}, "2001:db8::17"},
+		{"obfuscated forwarded stops the walk", "10.0.0.1:80", map[string]string{"Forwarded": "for=1.1.1.1, for=_hidden, for=10.0.0.5"}, "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			got := resolver.Resolve(req)
+			if got == nil || got.String() != tt.want {
+				t.Fatalf("expected %s, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewClientIPResolverInvalidCIDR(t *testing.T) {
+	if _, err := NewClientIPResolver([]string{"not-a-cidr"}); err == nil {
+		t.Fatalf("expected error for invalid cidr")
+	}
+}
@@ -0,0 +1,361 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	generationCurrentFile = "CURRENT"
+	generationSkipFile    = "SKIP"
+	generationIDLayout    = "20060102T150405Z"
+)
+
+var (
+	ErrGenerationNotFound  = errors.New("database generation not found")
+	ErrGenerationsDisabled = errors.New("database generations not kept")
+)
+
+// Generation is a database version kept on disk for rollback. Checksum is
+// the archive checksum as published by MaxMind; SHA256 is the digest of the
+// .mmdb file itself.
+type Generation struct {
+	ID           string    `json:"id"`
+	EditionID    string    `json:"edition_id"`
+	DatabaseType string    `json:"database_type"`
+	BuildTime    time.Time `json:"build_time"`
+	InstalledAt  time.Time `json:"installed_at"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	Checksum     string    `json:"checksum,omitempty"`
+	Current      bool      `json:"current"`
+}
+
+// GenerationStore keeps the last generations of the database at target in a
+// "<name>.generations" directory next to it. Each generation is a hard link
+// (or a copy where links are not possible) plus a JSON metadata file.
+type GenerationStore struct {
+	editionID string
+	target    string
+	dir       string
+	keep      int
+	now       func() time.Time
+}
+
+func NewGenerationStore(editionID, target string, keep int) *GenerationStore {
+	return &GenerationStore{
+		editionID: editionID,
+		target:    target,
+		dir:       strings.TrimSuffix(target, filepath.Ext(target)) + ".generations",
+		keep:      keep,
+		now:       time.Now,
+	}
+}
+
+func (g *GenerationStore) Dir() string {
+	return g.dir
+}
+
+// List returns the kept generations, newest first.
+func (g *GenerationStore) List() ([]Generation, error) {
+	entries, err := os.ReadDir(g.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	current := g.current()
+	var generations []Generation
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		generation, err := g.get(id)
+		if err != nil {
+			return nil, err
+		}
+		generation.Current = id == current
+		generations = append(generations, generation)
+	}
+
+	sort.Slice(generations, func(i, j int) bool { return generations[i].ID > generations[j].ID })
+	return generations, nil
+}
+
+// add keeps the file at path, which is about to be installed, as a new
+// generation. It does not become current until markInstalled.
+func (g *GenerationStore) add(path, checksum, sum string) (Generation, error) {
+	if err := os.MkdirAll(g.dir, 0o755); err != nil {
+		return Generation{}, err
+	}
+
+	generation, err := describeDatabase(path)
+	if err != nil {
+		return Generation{}, err
+	}
+	if sum == "" {
+		if sum, err = fileSHA256(path); err != nil {
+			return Generation{}, err
+		}
+	}
+	generation.EditionID = g.editionID
+	generation.SHA256 = sum
+	generation.Checksum = checksum
+	generation.InstalledAt = g.now().UTC()
+	generation.ID = g.newID(generation.InstalledAt)
+
+	if err := linkOrCopy(path, g.databasePath(generation.ID)); err != nil {
+		return Generation{}, err
+	}
+
+	data, err := json.MarshalIndent(generation, "", "  ")
+	if err != nil {
+		return Generation{}, err
+	}
+	if err := os.WriteFile(g.metadataPath(generation.ID), data, 0o644); err != nil {
+		_ = os.Remove(g.databasePath(generation.ID))
+		return Generation{}, err
+	}
+	return generation, nil
+}
+
+// snapshot keeps the installed database as a generation when it is not one
+// already, so the first update with generations enabled can be rolled back.
+func (g *GenerationStore) snapshot(checksum string) error {
+	if _, err := os.Stat(g.target); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if current := g.current(); current != "" {
+		if _, err := os.Stat(g.databasePath(current)); err == nil {
+			return nil
+		}
+	}
+
+	generation, err := g.add(g.target, checksum, "")
+	if err != nil {
+		return fmt.Errorf("snapshot installed database: %w", err)
+	}
+	return g.setCurrent(generation.ID)
+}
+
+// markInstalled makes id current, lifts a skip left by a rollback and prunes
+// generations beyond the ones to keep.
+func (g *GenerationStore) markInstalled(id string) error {
+	if err := g.setCurrent(id); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(g.dir, generationSkipFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return g.prune()
+}
+
+// skipped reports whether checksum belongs to a database that was rolled
+// back, which scheduled updates should not reinstall.
+func (g *GenerationStore) skipped(checksum string) bool {
+	data, err := os.ReadFile(filepath.Join(g.dir, generationSkipFile))
+	if err != nil {
+		return false
+	}
+	skip := strings.TrimSpace(string(data))
+	return skip != "" && strings.EqualFold(skip, strings.TrimSpace(checksum))
+}
+
+// Rollback installs generation id over the target file and makes it current.
+// The checksum of the generation it replaces is remembered so scheduled
+// updates do not download it again; a forced update still does. The caller
+// reloads the database.
+func (g *GenerationStore) Rollback(id string) (Generation, error) {
+	generation, err := g.get(id)
+	if err != nil {
+		return Generation{}, err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(g.target), "geoip-*.mmdb")
+	if err != nil {
+		return Generation{}, err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	_ = os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+
+	if err := linkOrCopy(g.databasePath(id), tmpPath); err != nil {
+		return Generation{}, err
+	}
+	if err := validateDatabase(tmpPath, g.editionID, "", DefaultCanaryIPs); err != nil {
+		return Generation{}, err
+	}
+
+	var replaced Generation
+	if current := g.current(); current != "" && current != id {
+		replaced, _ = g.get(current)
+	}
+
+	if err := replaceFile(tmpPath, g.target); err != nil {
+		return Generation{}, err
+	}
+	if err := os.WriteFile(g.target+DefaultChecksumExt, []byte(generation.Checksum+"\n"), 0o644); err != nil {
+		return Generation{}, err
+	}
+	if err := g.setCurrent(id); err != nil {
+		return Generation{}, err
+	}
+	if replaced.Checksum != "" {
+		if err := os.WriteFile(filepath.Join(g.dir, generationSkipFile), []byte(replaced.Checksum+"\n"), 0o644); err != nil {
+			return Generation{}, err
+		}
+	}
+
+	generation.Current = true
+	return generation, nil
+}
+
+func (g *GenerationStore) prune() error {
+	generations, err := g.List()
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	var errs []error
+	for _, generation := range generations {
+		if generation.Current || kept < g.keep {
+			kept++
+			continue
+		}
+		if err := os.Remove(g.databasePath(generation.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		if err := os.Remove(g.metadataPath(generation.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g *GenerationStore) get(id string) (Generation, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return Generation{}, ErrGenerationNotFound
+	}
+
+	data, err := os.ReadFile(g.metadataPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Generation{}, ErrGenerationNotFound
+		}
+		return Generation{}, err
+	}
+
+	var generation Generation
+	if err := json.Unmarshal(data, &generation); err != nil {
+		return Generation{}, fmt.Errorf("read generation %s: %w", id, err)
+	}
+	return generation, nil
+}
+
+func (g *GenerationStore) current() string {
+	data, err := os.ReadFile(filepath.Join(g.dir, generationCurrentFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (g *GenerationStore) setCurrent(id string) error {
+	return os.WriteFile(filepath.Join(g.dir, generationCurrentFile), []byte(id+"\n"), 0o644)
+}
+
+// newID names a generation after its install time, with a suffix when two
+// installs fall in the same second.
+func (g *GenerationStore) newID(at time.Time) string {
+	base := at.Format(generationIDLayout)
+	id := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(g.metadataPath(id)); errors.Is(err, os.ErrNotExist) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func (g *GenerationStore) databasePath(id string) string {
+	return filepath.Join(g.dir, id+".mmdb")
+}
+
+func (g *GenerationStore) metadataPath(id string) string {
+	return filepath.Join(g.dir, id+".json")
+}
+
+func describeDatabase(path string) (Generation, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return Generation{}, err
+	}
+	defer reader.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Generation{}, err
+	}
+
+	return Generation{
+		DatabaseType: reader.Metadata.DatabaseType,
+		BuildTime:    time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC(),
+		Size:         info.Size(),
+	}, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newGenerationTestDownloader(t *testing.T, targetPath string, keep int, checksum *string, payload *[]byte) (*DatabaseDownloader, *time.Time) {
+	t.Helper()
+
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewGenerationStore(DefaultEditionID, targetPath, keep)
+	store.now = func() time.Time { return clock }
+
+	downloader := NewDatabaseDownloader("license-key", targetPath, time.Second, 0)
+	downloader.DownloadURL = "https://example.com/download"
+	downloader.ChecksumURL = "https://example.com/checksum"
+	downloader.httpClient = newMockHTTPClient(checksum, payload)
+	downloader.Generations = store
+	return downloader, &clock
+}
+
+func TestGenerationsKeptAndPruned(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+
+	checksumValue := "v1"
+	payload := buildDatabase(t, DefaultEditionID, 1)
+	downloader, clock := newGenerationTestDownloader(t, targetPath, 2, &checksumValue, &payload)
+
+	for i, version := range []string{"v1", "v2", "v3"} {
+		checksumValue = version
+		payload = buildDatabase(t, DefaultEditionID, uint64(i+1))
+		*clock = clock.Add(time.Hour)
+
+		if updated, reason, err := downloader.EnsureLatest(context.Background(), false); err != nil || !updated {
+			t.Fatalf("%s: updated=%v reason=%q err=%v", version, updated, reason, err)
+		}
+	}
+
+	generations, err := downloader.Generations.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(generations) != 2 {
+		t.Fatalf("expected 2 generations after pruning, got %+v", generations)
+	}
+
+	newest, previous := generations[0], generations[1]
+	if !newest.Current || previous.Current {
+		t.Fatalf("expected only the newest generation to be current: %+v", generations)
+	}
+	if newest.ID != "20240101T030000Z" || previous.ID != "20240101T020000Z" {
+		t.Fatalf("unexpected generation ids %s, %s", newest.ID, previous.ID)
+	}
+	if newest.Checksum != mockChecksum("v3", payload) || newest.BuildTime.Unix() != 3 || newest.DatabaseType != DefaultEditionID {
+		t.Fatalf("unexpected metadata: %+v", newest)
+	}
+	sum, err := fileSHA256(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newest.SHA256 != sum || newest.Size != int64(len(payload)) {
+		t.Fatalf("expected metadata to describe the installed file: %+v", newest)
+	}
+
+	if _, err := os.Stat(filepath.Join(downloader.Generations.Dir(), "20240101T010000Z.mmdb")); !os.IsNotExist(err) {
+		t.Fatalf("expected the oldest generation to be pruned, got %v", err)
+	}
+}
+
+func TestGenerationRollback(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+
+	checksumValue := "v1"
+	v1 := buildDatabase(t, DefaultEditionID, 1)
+	payload := v1
+	downloader, clock := newGenerationTestDownloader(t, targetPath, 3, &checksumValue, &payload)
+
+	if _, _, err := downloader.EnsureLatest(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := downloader.Generations.List()
+
+	checksumValue = "v2"
+	payload = buildDatabase(t, DefaultEditionID, 2)
+	*clock = clock.Add(time.Hour)
+	if _, _, err := downloader.EnsureLatest(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	generation, err := downloader.Generations.Rollback(first[0].ID)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if !generation.Current || generation.ID != first[0].ID {
+		t.Fatalf("unexpected generation: %+v", generation)
+	}
+	verifyFileContent(t, targetPath, v1)
+	verifyFileContent(t, targetPath+DefaultChecksumExt, []byte(mockChecksum("v1", v1)+"\n"))
+
+	updated, reason, err := downloader.EnsureLatest(context.Background(), false)
+	if err != nil || updated {
+		t.Fatalf("expected the rolled back release to be skipped, updated=%v err=%v", updated, err)
+	}
+	if reason != "remote database was rolled back, force an update to reinstall it" {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+
+	*clock = clock.Add(time.Hour)
+	if updated, _, err := downloader.EnsureLatest(context.Background(), true); err != nil || !updated {
+		t.Fatalf("expected a forced update to reinstall, updated=%v err=%v", updated, err)
+	}
+	verifyFileContent(t, targetPath, payload)
+	if downloader.Generations.skipped(mockChecksum("v2", payload)) {
+		t.Fatalf("expected the skip to be lifted after an install")
+	}
+
+	if _, err := downloader.Generations.Rollback("missing"); !errors.Is(err, ErrGenerationNotFound) {
+		t.Fatalf("expected ErrGenerationNotFound, got %v", err)
+	}
+	if _, err := downloader.Generations.Rollback("../GeoLite2-City"); !errors.Is(err, ErrGenerationNotFound) {
+		t.Fatalf("expected path ids to be rejected, got %v", err)
+	}
+}
+
+func TestGenerationSnapshotsInstalledDatabase(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	installed := buildDatabase(t, DefaultEditionID, 1)
+	if err := os.WriteFile(targetPath, installed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(targetPath+DefaultChecksumExt, []byte("old-checksum\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	checksumValue := "v2"
+	payload := buildDatabase(t, DefaultEditionID, 2)
+	downloader, clock := newGenerationTestDownloader(t, targetPath, 3, &checksumValue, &payload)
+	*clock = clock.Add(time.Hour)
+
+	if _, _, err := downloader.EnsureLatest(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	generations, err := downloader.Generations.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generations) != 2 || generations[1].Checksum != "old-checksum" || generations[1].Current {
+		t.Fatalf("expected the installed database to be kept, got %+v", generations)
+	}
+
+	if _, err := downloader.Generations.Rollback(generations[1].ID); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	verifyFileContent(t, targetPath, installed)
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const LocaleAll = "all"
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language header
+// ordered by quality. Wildcards and tags with q=0 are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(key) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				quality = q
+			}
+		}
+
+		if quality <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag: tag, quality: quality})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].quality > tags[j].quality
+	})
+
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, tag.tag)
+	}
+	return result
+}
+
+// LocaleChain expands the requested locales with their base language and
+// appends the fallback chain, e.g. [pt-BR] with fallback [en] becomes
+// [pt-BR pt en]. Duplicates are removed keeping the first occurrence.
+func LocaleChain(requested, fallback []string) []string {
+	seen := make(map[string]bool)
+	var chain []string
+
+	add := func(locale string) {
+		key := strings.ToLower(locale)
+		if locale == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		chain = append(chain, locale)
+	}
+
+	for _, locale := range requested {
+		locale = strings.TrimSpace(locale)
+		add(locale)
+		if base, _, found := strings.Cut(locale, "-"); found {
+			add(base)
+		}
+	}
+
+	for _, locale := range fallback {
+		add(strings.TrimSpace(locale))
+	}
+
+	return chain
+}
+
+// SelectName returns a single-entry map holding the first locale of chain
+// present in names, or nil when none of them is available.
+func SelectName(names map[string]string, chain []string) map[string]string {
+	if len(names) == 0 {
+		return names
+	}
+
+	for _, locale := range chain {
+		for key, value := range names {
+			if strings.EqualFold(key, locale) {
+				return map[string]string{key: value}
+			}
+		}
+	}
+	return nil
+}
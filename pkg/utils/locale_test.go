@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"pt-BR", []string{"pt-BR"}},
+		{"fr;q=0.5, pt-BR, en;q=0.8", []string{"pt-BR", "en", "fr"}},
+		{"de;q=0, *, es", []string{"es"}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("ParseAcceptLanguage(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleChain(t *testing.T) {
+	got := LocaleChain([]string{"pt-BR", "PT", "es"}, []string{"en", "pt"})
+	want := []string{"pt-BR", "pt", "es", "en"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("LocaleChain = %v, want %v", got, want)
+	}
+}
+
+func TestSelectName(t *testing.T) {
+	names := map[string]string{
+		"en":    "Munich",
+		"de":    "München",
+		"pt-BR": "Munique",
+	}
+
+	tests := []struct {
+		chain []string
+		want  map[string]string
+	}{
+		{[]string{"pt-br", "pt", "en"}, map[string]string{"pt-BR": "Munique"}},
+		{[]string{"fr", "en"}, map[string]string{"en": "Munich"}},
+		{[]string{"ja"}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := SelectName(names, tt.chain); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("SelectName(%v) = %v, want %v", tt.chain, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +15,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/thiagozs/geolocation-go/pkg/metrics"
 )
 
 const DefaultDownloadURL = "https://download.maxmind.com/app/geoip_download?suffix=tar.gz"
@@ -21,30 +25,64 @@ const DefaultChecksumURL = "https://download.maxmind.com/app/geoip_download?suff
 
 const DefaultChecksumExt = ".sha256"
 
+const DefaultEditionID = "GeoLite2-City"
+
 const defaultHTTPTimeout = 30 * time.Second
 
+// ErrChecksumMismatch is wrapped by ChecksumError.
+var ErrChecksumMismatch = errors.New("archive checksum mismatch")
+
+// ChecksumError reports a downloaded archive whose SHA-256 differs from the
+// one published at ChecksumURL. The database on disk is left untouched.
+type ChecksumError struct {
+	EditionID string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: %v: expected sha256 %s, got %s", e.EditionID, ErrChecksumMismatch, e.Expected, e.Actual)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
 type DatabaseDownloader struct {
 	LicenseKey         string
+	EditionID          string
 	TargetFilePath     string
 	localChecksumPath  string
 	DownloadURL        string
 	ChecksumURL        string
 	httpClient         *http.Client
 	MinRefreshInterval time.Duration
+	CanaryIPs          []string
+	// Generations, when set, keeps installed databases for rollback
+	Generations *GenerationStore
 }
 
 func NewDatabaseDownloader(licenseKey, targetFilePath string, timeout, minRefresh time.Duration) *DatabaseDownloader {
+	return NewEditionDownloader(licenseKey, DefaultEditionID, targetFilePath, timeout, minRefresh)
+}
+
+func NewEditionDownloader(licenseKey, editionID, targetFilePath string, timeout, minRefresh time.Duration) *DatabaseDownloader {
 	if timeout <= 0 {
 		timeout = defaultHTTPTimeout
 	}
+	if editionID == "" {
+		editionID = DefaultEditionID
+	}
 	return &DatabaseDownloader{
 		LicenseKey:         licenseKey,
+		EditionID:          editionID,
 		TargetFilePath:     targetFilePath,
 		localChecksumPath:  targetFilePath + DefaultChecksumExt,
 		DownloadURL:        DefaultDownloadURL,
 		ChecksumURL:        DefaultChecksumURL,
 		httpClient:         &http.Client{Timeout: timeout},
 		MinRefreshInterval: minRefresh,
+		CanaryIPs:          DefaultCanaryIPs,
 	}
 }
 
@@ -137,6 +175,10 @@ func (downloader *DatabaseDownloader) EnsureLatest(ctx context.Context, force bo
 		return false, "database already up to date", nil
 	}
 
+	if downloader.Generations != nil && downloader.Generations.skipped(remoteChecksum) {
+		return false, "remote database was rolled back, force an update to reinstall it", nil
+	}
+
 	if err := downloader.download(ctx, remoteChecksum); err != nil {
 		return false, "", err
 	}
@@ -144,7 +186,25 @@ func (downloader *DatabaseDownloader) EnsureLatest(ctx context.Context, force bo
 	return true, "remote checksum changed", nil
 }
 
+// download streams the archive, hashing it on the way, and installs the
+// .mmdb it contains only when the hash matches remoteChecksum and the file
+// passes validateDatabase. The checksum is fetched first when remoteChecksum
+// is empty.
 func (downloader *DatabaseDownloader) download(ctx context.Context, remoteChecksum string) error {
+	if remoteChecksum == "" {
+		checksum, err := downloader.RemoteChecksum(ctx)
+		if err != nil {
+			return err
+		}
+		remoteChecksum = checksum
+	}
+
+	expected, err := parseChecksum(remoteChecksum)
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
 	resp, err := downloader.doGETRequest(ctx, downloader.DownloadURL)
 	if err != nil {
 		return err
@@ -155,14 +215,27 @@ func (downloader *DatabaseDownloader) download(ctx context.Context, remoteChecks
 		return fmt.Errorf("unexpected download status code: %d", resp.StatusCode)
 	}
 
-	uncompressedStream, err := gzip.NewReader(resp.Body)
+	hash := sha256.New()
+	body := &countingReader{reader: io.TeeReader(resp.Body, hash)}
+	defer func() {
+		metrics.DownloadBytes.WithLabelValues(downloader.EditionID).Add(float64(body.count))
+		metrics.DownloadDuration.WithLabelValues(downloader.EditionID).Observe(time.Since(started).Seconds())
+	}()
+
+	uncompressedStream, err := gzip.NewReader(body)
 	if err != nil {
 		return err
 	}
 	defer uncompressedStream.Close()
 
 	tarReader := tar.NewReader(uncompressedStream)
-	foundFile := false
+	databaseHash := sha256.New()
+	tmpPath := ""
+	defer func() {
+		if tmpPath != "" {
+			_ = os.Remove(tmpPath)
+		}
+	}()
 
 	for {
 		header, err := tarReader.Next()
@@ -187,47 +260,82 @@ func (downloader *DatabaseDownloader) download(ctx context.Context, remoteChecks
 			return err
 		}
 
-		tmpPath := tmpFile.Name()
+		tmpPath = tmpFile.Name()
 
-		if _, err := io.Copy(tmpFile, tarReader); err != nil {
+		if _, err := io.Copy(io.MultiWriter(tmpFile, databaseHash), tarReader); err != nil {
 			tmpFile.Close()
-			_ = os.Remove(tmpPath)
 			return err
 		}
 
 		if err := tmpFile.Close(); err != nil {
-			_ = os.Remove(tmpPath)
 			return err
 		}
 
-		if err := replaceFile(tmpPath, downloader.TargetFilePath); err != nil {
-			_ = os.Remove(tmpPath)
-			return err
-		}
-
-		foundFile = true
 		break
 	}
 
-	if !foundFile {
+	if tmpPath == "" {
 		return errors.New("invalid download, tgz doesn't contain a .mmdb file")
 	}
 
-	if remoteChecksum == "" {
-		checksum, err := downloader.RemoteChecksum(ctx)
+	// The checksum covers the whole archive, including what follows the
+	// .mmdb entry.
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return &ChecksumError{EditionID: downloader.EditionID, Expected: expected, Actual: actual}
+	}
+
+	if err := validateDatabase(tmpPath, downloader.EditionID, downloader.TargetFilePath, downloader.CanaryIPs); err != nil {
+		return err
+	}
+
+	var generation Generation
+	if downloader.Generations != nil {
+		localChecksum, err := downloader.LocalChecksum()
 		if err != nil {
 			return err
 		}
-		remoteChecksum = checksum
+		if err := downloader.Generations.snapshot(localChecksum); err != nil {
+			return err
+		}
+		generation, err = downloader.Generations.add(tmpPath, remoteChecksum, hex.EncodeToString(databaseHash.Sum(nil)))
+		if err != nil {
+			return fmt.Errorf("keep database generation: %w", err)
+		}
+	}
+
+	if err := replaceFile(tmpPath, downloader.TargetFilePath); err != nil {
+		return err
 	}
+	tmpPath = ""
 
 	if err := os.WriteFile(downloader.localChecksumPath, []byte(remoteChecksum+"\n"), 0o644); err != nil {
 		return err
 	}
 
+	if downloader.Generations != nil {
+		return downloader.Generations.markInstalled(generation.ID)
+	}
 	return nil
 }
 
+// parseChecksum returns the hex digest of a .sha256 file, which MaxMind
+// publishes as "<digest>  <archive name>".
+func parseChecksum(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return "", errors.New("empty remote checksum")
+	}
+	digest := fields[0]
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid remote checksum %q", digest)
+	}
+	return digest, nil
+}
+
 func (downloader *DatabaseDownloader) doGETRequest(ctx context.Context, urlString string) (*http.Response, error) {
 	parsedURL, err := url.Parse(urlString)
 	if err != nil {
@@ -235,7 +343,7 @@ func (downloader *DatabaseDownloader) doGETRequest(ctx context.Context, urlStrin
 	}
 
 	q := parsedURL.Query()
-	q.Set("edition_id", "GeoLite2-City")
+	q.Set("edition_id", downloader.EditionID)
 	q.Set("license_key", downloader.LicenseKey)
 	parsedURL.RawQuery = q.Encode()
 
@@ -250,6 +358,12 @@ func (downloader *DatabaseDownloader) doGETRequest(ctx context.Context, urlStrin
 
 	resp, err := downloader.httpClient.Do(req)
 	if err != nil {
+		// the request URL carries the license key, which must not end up in
+		// logs or status responses
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && downloader.LicenseKey != "" {
+			urlErr.URL = strings.ReplaceAll(urlErr.URL, url.QueryEscape(downloader.LicenseKey), "REDACTED")
+		}
 		return nil, err
 	}
 
@@ -277,9 +391,20 @@ func (downloader *DatabaseDownloader) fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// replaceFile renames tmpPath over target in one step, so readers opening
+// target see either the old or the new database.
 func replaceFile(tmpPath, target string) error {
-	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
-		return err
-	}
 	return os.Rename(tmpPath, target)
 }
@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +16,10 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thiagozs/geolocation-go/internal/mmdbtest"
+	"github.com/thiagozs/geolocation-go/pkg/metrics"
 )
 
 func TestEnsureLatestDownloadsWhenMissing(t *testing.T) {
@@ -21,7 +28,7 @@ func TestEnsureLatestDownloadsWhenMissing(t *testing.T) {
 	checksumPath := targetPath + DefaultChecksumExt
 
 	checksumValue := "checksum-initial"
-	payload := []byte("dummy maxmind payload v1")
+	payload := buildDatabase(t, DefaultEditionID, 1)
 
 	downloader := NewDatabaseDownloader("license-key", targetPath, time.Second, 0)
 	downloader.DownloadURL = "https://example.com/download"
@@ -38,7 +45,7 @@ func TestEnsureLatestDownloadsWhenMissing(t *testing.T) {
 	}
 
 	verifyFileContent(t, targetPath, payload)
-	verifyFileContent(t, checksumPath, []byte(checksumValue+"\n"))
+	verifyFileContent(t, checksumPath, []byte(mockChecksum(checksumValue, payload)+"\n"))
 
 	// second call with same checksum should skip download
 	updated, reason, err = downloader.EnsureLatest(context.Background(), false)
@@ -60,7 +67,7 @@ func TestEnsureLatestRespectsRefreshIntervalAndForce(t *testing.T) {
 	targetPath := filepath.Join(tempDir, "GeoLite2-City.mmdb")
 
 	checksumValue := "checksum-v1"
-	payload := []byte("payload v1")
+	payload := buildDatabase(t, DefaultEditionID, 1)
 
 	downloader := NewDatabaseDownloader("license-key", targetPath, time.Second, time.Hour)
 	downloader.DownloadURL = "https://example.com/download"
@@ -72,7 +79,7 @@ func TestEnsureLatestRespectsRefreshIntervalAndForce(t *testing.T) {
 	}
 
 	checksumValue = "checksum-v2"
-	payload = []byte("payload v2")
+	payload = buildDatabase(t, DefaultEditionID, 2)
 
 	updated, reason, err := downloader.EnsureLatest(context.Background(), false)
 	if err != nil {
@@ -99,6 +106,184 @@ func TestEnsureLatestRespectsRefreshIntervalAndForce(t *testing.T) {
 	verifyFileContent(t, targetPath, payload)
 }
 
+func TestEditionDownloaderRequestsConfiguredEdition(t *testing.T) {
+	tempDir := t.TempDir()
+	targetPath := filepath.Join(tempDir, "GeoLite2-ASN.mmdb")
+
+	checksumValue := "checksum-asn"
+	payload := buildDatabase(t, "GeoLite2-ASN", 1)
+
+	var editions []string
+	mock := newMockHTTPClient(&checksumValue, &payload)
+	transport := mock.Transport
+	mock.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		editions = append(editions, req.URL.Query().Get("edition_id"))
+		return transport.RoundTrip(req)
+	})
+
+	downloader := NewEditionDownloader("license-key", "GeoLite2-ASN", targetPath, time.Second, 0)
+	downloader.DownloadURL = "https://example.com/download"
+	downloader.ChecksumURL = "https://example.com/checksum"
+	downloader.httpClient = mock
+
+	if updated, _, err := downloader.EnsureLatest(context.Background(), false); err != nil || !updated {
+		t.Fatalf("download failed: updated=%v err=%v", updated, err)
+	}
+
+	if len(editions) == 0 {
+		t.Fatalf("expected requests to be made")
+	}
+
+	for _, edition := range editions {
+		if edition != "GeoLite2-ASN" {
+			t.Fatalf("expected edition_id GeoLite2-ASN, got %q", edition)
+		}
+	}
+
+	verifyFileContent(t, targetPath, payload)
+	verifyFileContent(t, targetPath+DefaultChecksumExt, []byte(mockChecksum(checksumValue, payload)+"\n"))
+}
+
+func TestDownloaderRecordsDownloadMetrics(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "GeoLite2-Metrics.mmdb")
+
+	checksumValue := "checksum-metrics"
+	payload := buildDatabase(t, "GeoLite2-Metrics", 1)
+
+	downloader := NewEditionDownloader("license-key", "GeoLite2-Metrics", targetPath, time.Second, 0)
+	downloader.DownloadURL = "https://example.com/download"
+	downloader.ChecksumURL = "https://example.com/checksum"
+	downloader.httpClient = newMockHTTPClient(&checksumValue, &payload)
+
+	if _, _, err := downloader.EnsureLatest(context.Background(), true); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.DownloadBytes.WithLabelValues("GeoLite2-Metrics")); got <= 0 {
+		t.Fatalf("expected downloaded bytes to be counted, got %v", got)
+	}
+	if got := testutil.CollectAndCount(metrics.DownloadDuration); got == 0 {
+		t.Fatalf("expected download duration to be observed")
+	}
+}
+
+func TestDownloadRejectsCorruptedArchive(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([]byte) []byte
+	}{
+		{
+			name: "replaced payload",
+			tamper: func([]byte) []byte {
+				data, _ := buildTarArchive("GeoLite2-City.mmdb", []byte("tampered payload"))
+				return data
+			},
+		},
+		{
+			name: "trailing bytes",
+			tamper: func(data []byte) []byte {
+				return append(append([]byte{}, data...), 0)
+			},
+		},
+		{
+			name: "truncated",
+			tamper: func(data []byte) []byte {
+				return data[:len(data)/2]
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetPath := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+			original := []byte("installed payload")
+			if err := os.WriteFile(targetPath, original, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			checksumValue := "checksum-v2"
+			payload := buildDatabase(t, DefaultEditionID, 2)
+
+			downloader := NewDatabaseDownloader("license-key", targetPath, time.Second, 0)
+			downloader.DownloadURL = "https://example.com/download"
+			downloader.ChecksumURL = "https://example.com/checksum"
+			downloader.httpClient = newTamperingHTTPClient(&checksumValue, &payload, tt.tamper)
+
+			updated, _, err := downloader.EnsureLatest(context.Background(), true)
+			if err == nil || updated {
+				t.Fatalf("expected corrupted archive to be refused, updated=%v err=%v", updated, err)
+			}
+
+			verifyFileContent(t, targetPath, original)
+			if _, err := os.Stat(targetPath + DefaultChecksumExt); !os.IsNotExist(err) {
+				t.Fatalf("expected no checksum file to be written, got %v", err)
+			}
+			if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(targetPath), "geoip-*.mmdb")); len(matches) != 0 {
+				t.Fatalf("expected temporary files to be removed, got %v", matches)
+			}
+		})
+	}
+}
+
+func TestDownloadReportsChecksumError(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+
+	checksumValue := "checksum-v1"
+	payload := buildDatabase(t, DefaultEditionID, 1)
+
+	downloader := NewDatabaseDownloader("license-key", targetPath, time.Second, 0)
+	downloader.DownloadURL = "https://example.com/download"
+	downloader.ChecksumURL = "https://example.com/checksum"
+	downloader.httpClient = newTamperingHTTPClient(&checksumValue, &payload, func(data []byte) []byte {
+		tampered, _ := buildTarArchive("GeoLite2-City.mmdb", []byte("tampered"))
+		return tampered
+	})
+
+	_, _, err := downloader.EnsureLatest(context.Background(), false)
+
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) || !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ChecksumError, got %v", err)
+	}
+	if checksumErr.Expected == checksumErr.Actual || checksumErr.EditionID != DefaultEditionID {
+		t.Fatalf("unexpected error fields: %+v", checksumErr)
+	}
+	if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no database to be installed, got %v", err)
+	}
+}
+
+func TestDownloadErrorsDoNotLeakLicenseKey(t *testing.T) {
+	downloader := NewDatabaseDownloader("SECRET-LICENSE", filepath.Join(t.TempDir(), "GeoLite2-City.mmdb"), time.Second, 0)
+	downloader.DownloadURL = "https://example.com/download"
+	downloader.ChecksumURL = "https://example.com/checksum"
+	downloader.httpClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("dial tcp: connection refused")
+		}),
+	}
+
+	_, _, err := downloader.EnsureLatest(context.Background(), true)
+	if err == nil {
+		t.Fatalf("expected download error")
+	}
+	if strings.Contains(err.Error(), "SECRET-LICENSE") || !strings.Contains(err.Error(), "license_key=REDACTED") {
+		t.Fatalf("expected license key to be redacted, got %v", err)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	digest := strings.Repeat("ab", sha256.Size)
+	if got, err := parseChecksum(digest + "  GeoLite2-City_20240101.tar.gz\n"); err != nil || got != digest {
+		t.Fatalf("unexpected result %q, %v", got, err)
+	}
+	for _, value := range []string{"", "checksum-v1", digest[:10]} {
+		if _, err := parseChecksum(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
 type roundTripFunc func(*http.Request) (*http.Response, error)
 
 func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -106,11 +291,17 @@ func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func newMockHTTPClient(checksum *string, payload *[]byte) *http.Client {
+	return newTamperingHTTPClient(checksum, payload, nil)
+}
+
+// newTamperingHTTPClient serves the checksum of the genuine archive but passes
+// the archive through tamper before sending it.
+func newTamperingHTTPClient(checksum *string, payload *[]byte, tamper func([]byte) []byte) *http.Client {
 	return &http.Client{
 		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
 			switch req.URL.Path {
 			case "/checksum":
-				body := io.NopCloser(strings.NewReader(fmt.Sprintf("%s\n", *checksum)))
+				body := io.NopCloser(strings.NewReader(fmt.Sprintf("%s\n", mockChecksum(*checksum, *payload))))
 				return &http.Response{
 					StatusCode: http.StatusOK,
 					Body:       body,
@@ -121,6 +312,9 @@ func newMockHTTPClient(checksum *string, payload *[]byte) *http.Client {
 				if err != nil {
 					return nil, err
 				}
+				if tamper != nil {
+					data = tamper(data)
+				}
 
 				body := io.NopCloser(bytes.NewReader(data))
 				return &http.Response{
@@ -139,6 +333,17 @@ func newMockHTTPClient(checksum *string, payload *[]byte) *http.Client {
 	}
 }
 
+// mockChecksum is the .sha256 file served for payload, in MaxMind's
+// "<digest>  <archive name>" format with version naming the archive.
+func mockChecksum(version string, payload []byte) string {
+	data, err := buildTarArchive("GeoLite2-City.mmdb", payload)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s  GeoLite2-City_%s.tar.gz", hex.EncodeToString(sum[:]), version)
+}
+
 func buildTarArchive(name string, payload []byte) ([]byte, error) {
 	var buf bytes.Buffer
 	gzw := gzip.NewWriter(&buf)
@@ -169,6 +374,19 @@ func buildTarArchive(name string, payload []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// buildDatabase returns an mmdb of the given type with data for 8.8.8.0/24.
+func buildDatabase(t *testing.T, databaseType string, buildEpoch uint64) []byte {
+	t.Helper()
+
+	data, err := mmdbtest.Build(mmdbtest.Options{DatabaseType: databaseType, BuildEpoch: buildEpoch}, []mmdbtest.Network{
+		{CIDR: "8.8.8.0/24", Data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "US"}}},
+	})
+	if err != nil {
+		t.Fatalf("build database: %v", err)
+	}
+	return data
+}
+
 func verifyFileContent(t *testing.T, path string, expected []byte) {
 	t.Helper()
 
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ErrInvalidDatabase is wrapped by ValidationError.
+var ErrInvalidDatabase = errors.New("invalid database")
+
+// DefaultCanaryIPs are looked up in every downloaded database before it is
+// installed. Addresses of the wrong IP version for the database are skipped.
+var DefaultCanaryIPs = []string{"1.1.1.1", "8.8.8.8", "2001:4860:4860::8888"}
+
+// canaryDataTypes are the database types that cover every routed address, so
+// one without data for any canary is broken. Other types, such as
+// GeoIP2-Anonymous-IP, only list some networks; their canary lookups just
+// have to succeed.
+var canaryDataTypes = map[string]bool{
+	"geolite2-city":          true,
+	"geolite2-country":       true,
+	"geolite2-asn":           true,
+	"geoip2-city":            true,
+	"geoip2-country":         true,
+	"geoip2-enterprise":      true,
+	"geoip2-isp":             true,
+	"geoip2-connection-type": true,
+}
+
+// ValidationError reports a downloaded database that was not installed
+// because it failed a check. The database on disk is left untouched.
+type ValidationError struct {
+	EditionID string
+	Reason    string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v: %s", e.EditionID, ErrInvalidDatabase, e.Reason)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidDatabase
+}
+
+// validateDatabase checks a downloaded edition, which must not be older than
+// the database at currentPath.
+func validateDatabase(path, editionID, currentPath string, canaries []string) error {
+	var minBuildEpoch uint
+	if current, err := maxminddb.Open(currentPath); err == nil {
+		minBuildEpoch = current.Metadata.BuildEpoch
+		current.Close()
+	}
+	return ValidateDatabase(path, editionID, minBuildEpoch, canaries)
+}
+
+// ValidateDatabase opens the candidate at path and checks that its type is
+// databaseType, it was built no earlier than minBuildEpoch, it has a search
+// tree and it answers the canary lookups without errors. Types that cover
+// every address must also have data for at least one canary.
+func ValidateDatabase(path, databaseType string, minBuildEpoch uint, canaries []string) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &ValidationError{EditionID: databaseType, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return invalid("open: %v", err)
+	}
+	defer reader.Close()
+
+	meta := reader.Metadata
+	if !strings.EqualFold(meta.DatabaseType, databaseType) {
+		return invalid("database type %q does not match edition", meta.DatabaseType)
+	}
+	if meta.NodeCount == 0 {
+		return invalid("empty search tree")
+	}
+	if meta.BuildEpoch < minBuildEpoch {
+		return invalid("build epoch %d is older than installed %d", meta.BuildEpoch, minBuildEpoch)
+	}
+
+	found := 0
+	checked := 0
+	for _, canary := range canaries {
+		ip := net.ParseIP(canary)
+		if ip == nil || (meta.IPVersion == 4 && ip.To4() == nil) {
+			continue
+		}
+
+		checked++
+		var record interface{}
+		if err := reader.Lookup(ip, &record); err != nil {
+			return invalid("lookup %s: %v", canary, err)
+		}
+		if record != nil {
+			found++
+		}
+	}
+	if checked > 0 && found == 0 && canaryDataTypes[strings.ToLower(databaseType)] {
+		return invalid("no data for canary addresses %s", strings.Join(canaries, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/geolocation-go/internal/mmdbtest"
+)
+
+func TestValidateDatabase(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current.mmdb")
+	if err := os.WriteFile(current, buildDatabase(t, DefaultEditionID, 10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	noCanaries, err := mmdbtest.Build(mmdbtest.Options{BuildEpoch: 11}, []mmdbtest.Network{
+		{CIDR: "203.0.113.0/24", Data: map[string]interface{}{"city": "test"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		reason string
+	}{
+		{name: "valid", path: write("valid.mmdb", buildDatabase(t, DefaultEditionID, 11))},
+		{name: "same build", path: write("same.mmdb", buildDatabase(t, DefaultEditionID, 10))},
+		{name: "not an mmdb", path: write("garbage.mmdb", []byte("garbage")), reason: "open"},
+		{name: "wrong edition", path: write("asn.mmdb", buildDatabase(t, "GeoLite2-ASN", 11)), reason: "does not match edition"},
+		{name: "older build", path: write("old.mmdb", buildDatabase(t, DefaultEditionID, 9)), reason: "older than installed"},
+		{name: "canaries missing", path: write("empty.mmdb", noCanaries), reason: "no data for canary"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDatabase(tt.path, DefaultEditionID, current, DefaultCanaryIPs)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("expected database to pass, got %v", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || !errors.Is(err, ErrInvalidDatabase) {
+				t.Fatalf("expected ValidationError, got %v", err)
+			}
+			if !strings.Contains(validationErr.Reason, tt.reason) {
+				t.Fatalf("expected reason containing %q, got %q", tt.reason, validationErr.Reason)
+			}
+		})
+	}
+}
+
+func TestValidateSparseDatabaseWithoutCanaryData(t *testing.T) {
+	data, err := mmdbtest.Build(mmdbtest.Options{DatabaseType: "GeoIP2-Anonymous-IP", BuildEpoch: 1}, []mmdbtest.Network{
+		{CIDR: "203.0.113.0/24", Data: map[string]interface{}{"is_anonymous": true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "GeoIP2-Anonymous-IP.mmdb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ValidateDatabase(path, "GeoIP2-Anonymous-IP", 0, DefaultCanaryIPs); err != nil {
+		t.Fatalf("expected sparse edition to pass without canary data, got %v", err)
+	}
+}
+
+func TestDownloadKeepsCurrentDatabaseWhenValidationFails(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	installed := buildDatabase(t, DefaultEditionID, 5)
+	if err := os.WriteFile(targetPath, installed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	checksumValue := "checksum-old"
+	payload := buildDatabase(t, DefaultEditionID, 4)
+
+	downloader := NewDatabaseDownloader("license-key", targetPath, time.Second, 0)
+	downloader.DownloadURL = "https://example.com/download"
+	downloader.ChecksumURL = "https://example.com/checksum"
+	downloader.httpClient = newMockHTTPClient(&checksumValue, &payload)
+
+	updated, _, err := downloader.EnsureLatest(context.Background(), true)
+	if !errors.Is(err, ErrInvalidDatabase) || updated {
+		t.Fatalf("expected older database to be refused, updated=%v err=%v", updated, err)
+	}
+
+	verifyFileContent(t, targetPath, installed)
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(targetPath), "geoip-*.mmdb")); len(matches) != 0 {
+		t.Fatalf("expected temporary files to be removed, got %v", matches)
+	}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/geolocation-go/pkg/utils"
+	"github.com/thiagozs/geolocation-go/services"
+)
+
+func newAdminTestServer(t *testing.T, cfg AdminConfig) (*Server, *fakeGeoIP) {
+	t.Helper()
+
+	svc := &fakeGeoIP{updateStatus: services.UpdateStatus{Updated: true}}
+	s := newTestServer(t, svc)
+	s.cfg.Admin = cfg
+	s.RegisterRoutes()
+	return s, svc
+}
+
+func performAdminRequest(s *Server, target string, header http.Header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func signedHeader(secret, target, body string, at time.Time) http.Header {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	header := http.Header{}
+	header.Set(HeaderSignatureTimestamp, timestamp)
+	header.Set(HeaderSignature, AdminSignature(secret, timestamp, http.MethodPost, target, []byte(body)))
+	return header
+}
+
+func TestAdminUpdateRequiresConfiguredCredentials(t *testing.T) {
+	s, svc := newAdminTestServer(t, AdminConfig{})
+
+	resp := performAdminRequest(s, "/admin/db/update", nil, "")
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without admin config, got %d", resp.Code)
+	}
+	if len(svc.updateCalls) != 0 {
+		t.Fatalf("expected no update call")
+	}
+}
+
+func TestAdminUpdateBearerToken(t *testing.T) {
+	s, svc := newAdminTestServer(t, AdminConfig{Token: "s3cret"})
+
+	resp := performAdminRequest(s, "/admin/db/update", nil, "")
+	if resp.Code != http.StatusUnauthorized || resp.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with challenge, got %d", resp.Code)
+	}
+
+	resp = performAdminRequest(s, "/admin/db/update", http.Header{"Authorization": {"Bearer wrong"}}, "")
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for wrong token, got %d", resp.Code)
+	}
+
+	resp = performAdminRequest(s, "/admin/db/update?force=true", http.Header{"Authorization": {"Bearer s3cret"}}, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if len(svc.updateCalls) != 1 || !svc.updateCalls[0] {
+		t.Fatalf("expected one forced update, got %v", svc.updateCalls)
+	}
+}
+
+func TestAdminUpdateHMACSignature(t *testing.T) {
+	s, svc := newAdminTestServer(t, AdminConfig{HMACSecret: "hmac-key"})
+	target := "/admin/db/update?force=true"
+
+	resp := performAdminRequest(s, target, signedHeader("hmac-key", target, `{"a":1}`, time.Now()), `{"a":1}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+	}{
+		{"wrong secret", signedHeader("other", target, "", time.Now()), ""},
+		{"tampered body", signedHeader("hmac-key", target, "", time.Now()), "changed"},
+		{"expired", signedHeader("hmac-key", target, "", time.Now().Add(-time.Hour)), ""},
+		{"other path", signedHeader("hmac-key", "/admin/db/update", "", time.Now()), ""},
+	}
+	for _, tt := range tests {
+		resp := performAdminRequest(s, target, tt.header, tt.body)
+		if resp.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", tt.name, resp.Code)
+		}
+	}
+
+	if len(svc.updateCalls) != 1 {
+		t.Fatalf("expected only the valid request to update, got %d calls", len(svc.updateCalls))
+	}
+}
+
+func TestAdminUpdateRejectsReplayedSignature(t *testing.T) {
+	s, svc := newAdminTestServer(t, AdminConfig{HMACSecret: "hmac-key"})
+	target := "/admin/db/update?force=true"
+	header := signedHeader("hmac-key", target, "", time.Now())
+
+	if resp := performAdminRequest(s, target, header, ""); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	resp := performAdminRequest(s, target, header, "")
+	if resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "already used") {
+		t.Fatalf("expected replay to be rejected, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if len(svc.updateCalls) != 1 {
+		t.Fatalf("expected one update call, got %d", len(svc.updateCalls))
+	}
+
+	if resp := performAdminRequest(s, target, signedHeader("hmac-key", target, "", time.Now().Add(time.Second)), ""); resp.Code != http.StatusOK {
+		t.Fatalf("expected a new signature to be accepted, got %d", resp.Code)
+	}
+}
+
+func TestAdminSignatureBodyIsCapped(t *testing.T) {
+	s, svc := newAdminTestServer(t, AdminConfig{HMACSecret: "hmac-key"})
+	target := "/admin/db/update"
+	body := strings.Repeat("x", maxSignedBodyBytes+1)
+
+	resp := performAdminRequest(s, target, signedHeader("hmac-key", target, body, time.Now()), body)
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if len(svc.updateCalls) != 0 {
+		t.Fatalf("expected no update call")
+	}
+}
+
+func TestUsedSignaturesExpire(t *testing.T) {
+	used := &usedSignatures{seen: map[string]time.Time{}}
+	now := time.Unix(1700000000, 0)
+
+	if !used.add("sig", now.Add(time.Minute), now) {
+		t.Fatalf("expected first use to be accepted")
+	}
+	if used.add("sig", now.Add(time.Minute), now.Add(30*time.Second)) {
+		t.Fatalf("expected second use to be rejected")
+	}
+	used.add("other", now.Add(3*time.Minute), now.Add(2*time.Minute))
+	if _, ok := used.seen["sig"]; ok {
+		t.Fatalf("expected expired signature to be forgotten")
+	}
+}
+
+func TestLegacyUpdateRouteRequiresAdminCredentials(t *testing.T) {
+	s, svc := newAdminTestServer(t, AdminConfig{Token: "s3cret"})
+	if resp := performRequest(s.router, http.MethodGet, "/updatedb?force=true"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", resp.Code)
+	}
+	if len(svc.updateCalls) != 0 {
+		t.Fatalf("expected no update call, got %v", svc.updateCalls)
+	}
+
+	resp := performRequestWithHeader(s.router, http.MethodGet, "/updatedb?force=true", "Authorization", "Bearer s3cret")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the admin token, got %d", resp.Code)
+	}
+	if len(svc.updateCalls) != 1 || !svc.updateCalls[0] {
+		t.Fatalf("expected one forced update, got %v", svc.updateCalls)
+	}
+
+	s, _ = newAdminTestServer(t, AdminConfig{})
+	if resp := performRequest(s.router, http.MethodGet, "/updatedb"); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without admin config, got %d", resp.Code)
+	}
+}
+
+func TestLegacyUpdateRouteCanBeDisabled(t *testing.T) {
+	s, _ := newAdminTestServer(t, AdminConfig{Token: "s3cret", DisableLegacyUpdate: true})
+	if resp := performRequest(s.router, http.MethodGet, "/updatedb"); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for disabled legacy route, got %d", resp.Code)
+	}
+}
+
+type generationGeoIP struct {
+	fakeGeoIP
+	generations []utils.Generation
+	rollbackErr error
+	rolledBack  []string
+}
+
+func (g *generationGeoIP) Generations() ([]utils.Generation, error) {
+	return g.generations, nil
+}
+
+func (g *generationGeoIP) Rollback(edition, id string) (utils.Generation, error) {
+	g.rolledBack = append(g.rolledBack, edition+"/"+id)
+	if g.rollbackErr != nil {
+		return utils.Generation{}, g.rollbackErr
+	}
+	return utils.Generation{ID: id, EditionID: utils.DefaultEditionID, Current: true}, nil
+}
+
+func TestAdminDatabaseGenerations(t *testing.T) {
+	svc := &generationGeoIP{generations: []utils.Generation{{ID: "20240102T000000Z", Current: true}, {ID: "20240101T000000Z"}}}
+	s := newTestServer(t, svc)
+	s.cfg.Admin = AdminConfig{Token: "s3cret"}
+	s.RegisterRoutes()
+
+	if resp := performRequest(s.router, http.MethodGet, "/admin/db/generations"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected generations to require admin credentials, got %d", resp.Code)
+	}
+
+	resp := performRequestWithHeader(s.router, http.MethodGet, "/admin/db/generations", "Authorization", "Bearer s3cret")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", resp.Code, resp.Body)
+	}
+	var payload struct {
+		Data []utils.Generation `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Data) != 2 || !payload.Data[0].Current {
+		t.Fatalf("unexpected generations: %+v", payload.Data)
+	}
+
+	plain, _ := newAdminTestServer(t, AdminConfig{Token: "s3cret"})
+	resp = performRequestWithHeader(plain.router, http.MethodGet, "/admin/db/generations", "Authorization", "Bearer s3cret")
+	if resp.Code != http.StatusNotImplemented {
+		t.Fatalf("expected 501 for a service without generations, got %d", resp.Code)
+	}
+}
+
+func TestAdminDatabaseRollback(t *testing.T) {
+	svc := &generationGeoIP{}
+	s := newTestServer(t, svc)
+	s.cfg.Admin = AdminConfig{Token: "s3cret"}
+	s.RegisterRoutes()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer s3cret")
+
+	if resp := performAdminRequest(s, "/admin/db/rollback", header, ""); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without generation, got %d", resp.Code)
+	}
+
+	resp := performAdminRequest(s, "/admin/db/rollback?generation=20240101T000000Z&edition=GeoLite2-ASN", header, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", resp.Code, resp.Body)
+	}
+	if len(svc.rolledBack) != 1 || svc.rolledBack[0] != "GeoLite2-ASN/20240101T000000Z" {
+		t.Fatalf("unexpected rollback calls: %v", svc.rolledBack)
+	}
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: utils.ErrGenerationNotFound, want: http.StatusNotFound},
+		{err: fmt.Errorf("%w: GeoIP2-ISP", services.ErrUnknownEdition), want: http.StatusNotFound},
+		{err: utils.ErrGenerationsDisabled, want: http.StatusNotImplemented},
+		{err: &utils.ValidationError{EditionID: utils.DefaultEditionID, Reason: "empty search tree"}, want: http.StatusUnprocessableEntity},
+		{err: errors.New("disk full"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		svc.rollbackErr = tt.err
+		if resp := performAdminRequest(s, "/admin/db/rollback?generation=x", header, ""); resp.Code != tt.want {
+			t.Fatalf("%v: expected %d, got %d", tt.err, tt.want, resp.Code)
+		}
+	}
+}
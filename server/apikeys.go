@@ -0,0 +1,280 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	HeaderAPIKey = "X-API-Key"
+
+	ErrCodeMissingAPIKey = "missing_api_key"
+	ErrCodeInvalidAPIKey = "invalid_api_key"
+	ErrCodeQuotaExceeded = "quota_exceeded"
+	ErrCodeRateLimited   = "rate_limited"
+
+	apiKeyContextKey      = "api_key"
+	apiKeyStateContextKey = "api_key_state"
+	defaultQuotaPeriod    = 24 * time.Hour
+)
+
+// APIKeyEntry is one key in the keys file. Only the SHA-256 of the key is
+// stored (see HashAPIKey). Quota caps the requests per QuotaPeriod and
+// RateLimit the requests per second, with bursts of up to Burst; zero means
+// unlimited.
+type APIKeyEntry struct {
+	Name        string  `yaml:"name" json:"name"`
+	Hash        string  `yaml:"hash" json:"hash"`
+	Quota       int64   `yaml:"quota" json:"quota"`
+	QuotaPeriod string  `yaml:"quota_period" json:"quota_period"`
+	RateLimit   float64 `yaml:"rate_limit" json:"rate_limit"`
+	Burst       int     `yaml:"burst" json:"burst"`
+}
+
+type apiKeysFile struct {
+	Keys []APIKeyEntry `yaml:"keys" json:"keys"`
+}
+
+// APIKeyUsage is the usage of a key as reported by the admin endpoint.
+type APIKeyUsage struct {
+	Name          string    `json:"name"`
+	Requests      uint64    `json:"requests"`
+	Quota         int64     `json:"quota,omitempty"`
+	QuotaUsed     int64     `json:"quota_used"`
+	QuotaResetsAt time.Time `json:"quota_resets_at,omitempty"`
+	QuotaRejected uint64    `json:"quota_rejected"`
+	RateLimited   uint64    `json:"rate_limited"`
+	LastUsed      time.Time `json:"last_used,omitempty"`
+}
+
+// HashAPIKey returns the value to store in the keys file for key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type apiKeyStore struct {
+	byHash map[string]*apiKeyState
+	keys   []*apiKeyState
+	now    func() time.Time
+}
+
+type apiKeyState struct {
+	name    string
+	quota   int64
+	period  time.Duration
+	limiter *tokenBucket
+
+	mu            sync.Mutex
+	windowStart   time.Time
+	windowCount   int64
+	requests      uint64
+	quotaRejected uint64
+	rateLimited   uint64
+	lastUsed      time.Time
+}
+
+func loadAPIKeys(path string) (*apiKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file apiKeysFile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse api keys %s: %w", path, err)
+	}
+
+	return newAPIKeyStore(file.Keys, time.Now)
+}
+
+func newAPIKeyStore(entries []APIKeyEntry, now func() time.Time) (*apiKeyStore, error) {
+	store := &apiKeyStore{byHash: map[string]*apiKeyState{}, now: now}
+	for _, entry := range entries {
+		hash := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry.Hash), "sha256:"))
+		if len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("api key %q: hash must be a hex sha256", entry.Name)
+		}
+		if _, ok := store.byHash[hash]; ok {
+			return nil, fmt.Errorf("api key %q: duplicate hash", entry.Name)
+		}
+
+		period := defaultQuotaPeriod
+		if entry.QuotaPeriod != "" {
+			parsed, err := time.ParseDuration(entry.QuotaPeriod)
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("api key %q: invalid quota_period %q", entry.Name, entry.QuotaPeriod)
+			}
+			period = parsed
+		}
+
+		state := &apiKeyState{name: entry.Name, quota: entry.Quota, period: period}
+		if entry.RateLimit > 0 {
+			state.limiter = newTokenBucket(entry.RateLimit, entry.Burst, now())
+		}
+
+		store.byHash[hash] = state
+		store.keys = append(store.keys, state)
+	}
+	return store, nil
+}
+
+func (s *apiKeyStore) lookup(key string) (*apiKeyState, bool) {
+	state, ok := s.byHash[HashAPIKey(key)]
+	return state, ok
+}
+
+func (s *apiKeyStore) usage() []APIKeyUsage {
+	usage := make([]APIKeyUsage, 0, len(s.keys))
+	for _, key := range s.keys {
+		usage = append(usage, key.usage(s.now()))
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return usage
+}
+
+// admit counts a request against the key and reports the error code when it
+// is over its rate limit or quota, with the time until it may retry. The
+// state of the key's own rate limit, if any, is returned for the headers.
+func (k *apiKeyState) admit(now time.Time) (string, time.Duration, bucketState) {
+	var limit bucketState
+	if k.limiter != nil {
+		if limit = k.limiter.take(now); !limit.allowed {
+			k.mu.Lock()
+			k.rateLimited++
+			k.mu.Unlock()
+			return ErrCodeRateLimited, limit.wait, limit
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.rollWindow(now)
+	if k.quota > 0 && k.windowCount >= k.quota {
+		k.quotaRejected++
+		return ErrCodeQuotaExceeded, k.windowStart.Add(k.period).Sub(now), limit
+	}
+
+	k.windowCount++
+	k.requests++
+	k.lastUsed = now
+	return "", 0, limit
+}
+
+func (k *apiKeyState) rollWindow(now time.Time) {
+	if k.windowStart.IsZero() || !now.Before(k.windowStart.Add(k.period)) {
+		k.windowStart = now
+		k.windowCount = 0
+	}
+}
+
+func (k *apiKeyState) usage(now time.Time) APIKeyUsage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.rollWindow(now)
+	usage := APIKeyUsage{
+		Name:          k.name,
+		Requests:      k.requests,
+		Quota:         k.quota,
+		QuotaUsed:     k.windowCount,
+		QuotaRejected: k.quotaRejected,
+		RateLimited:   k.rateLimited,
+		LastUsed:      k.lastUsed,
+	}
+	if k.quota > 0 {
+		usage.QuotaResetsAt = k.windowStart.Add(k.period)
+	}
+	return usage
+}
+
+// apiKeyAuth requires a known key on lookup routes when a keys file is
+// configured. The key name is stored in the context under "api_key" for the
+// per-key rate limit and apiKeyQuota that follow it.
+func (s *Server) apiKeyAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.apiKeys == nil {
+			c.Next()
+			return
+		}
+
+		key := strings.TrimSpace(c.GetHeader(HeaderAPIKey))
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "missing api key", "code": ErrCodeMissingAPIKey})
+			return
+		}
+
+		state, ok := s.apiKeys.lookup(key)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid api key", "code": ErrCodeInvalidAPIKey})
+			return
+		}
+
+		c.Set(apiKeyContextKey, state.name)
+		c.Set(apiKeyStateContextKey, state)
+		c.Next()
+	}
+}
+
+// apiKeyQuota enforces the rate limit and quota of the key found by
+// apiKeyAuth. It runs after the per-key rate limit so requests rejected there
+// are not charged to the quota.
+func (s *Server) apiKeyQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(apiKeyStateContextKey)
+		if !ok {
+			c.Next()
+			return
+		}
+		state := value.(*apiKeyState)
+
+		code, wait, limit := state.admit(s.apiKeys.now())
+		if limit.limit > 0 {
+			setRateLimitHeaders(c, limit)
+		}
+		if code != "" {
+			c.Header("Retry-After", retryAfter(wait))
+			message := "api key quota exceeded"
+			if code == ErrCodeRateLimited {
+				message = "api key rate limit exceeded"
+			}
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": message, "code": code})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// retryAfter formats wait as whole seconds, rounded up and at least one.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
+
+func (s *Server) APIKeyUsage(c *gin.Context) {
+	if s.apiKeys == nil {
+		c.JSON(http.StatusOK, gin.H{"data": []APIKeyUsage{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": s.apiKeys.usage()})
+}
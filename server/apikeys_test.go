@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/geolocation-go/models"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newAPIKeyTestServer(t *testing.T, entries ...APIKeyEntry) (*Server, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store, err := newAPIKeyStore(entries, clock.Now)
+	if err != nil {
+		t.Fatalf("newAPIKeyStore: %v", err)
+	}
+
+	s := newTestServer(t, &fakeGeoIP{record: models.Record{IP: "1.1.1.1"}})
+	s.apiKeys = store
+	s.cfg.Admin = AdminConfig{Token: "admin"}
+	s.RegisterRoutes()
+	return s, clock
+}
+
+func errorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	return payload.Code
+}
+
+func TestAPIKeyAuthRejectsMissingAndUnknownKeys(t *testing.T) {
+	s, _ := newAPIKeyTestServer(t, APIKeyEntry{Name: "billing", Hash: HashAPIKey("k1")})
+
+	resp := performRequest(s.router, http.MethodGet, "/ip?address=1.1.1.1")
+	if resp.Code != http.StatusUnauthorized || errorCode(t, resp.Body.Bytes()) != ErrCodeMissingAPIKey {
+		t.Fatalf("expected 401 missing_api_key, got %d %s", resp.Code, resp.Body)
+	}
+
+	resp = performRequestWithHeader(s.router, http.MethodGet, "/v1/ip?address=1.1.1.1", HeaderAPIKey, "nope")
+	if resp.Code != http.StatusUnauthorized || errorCode(t, resp.Body.Bytes()) != ErrCodeInvalidAPIKey {
+		t.Fatalf("expected 401 invalid_api_key, got %d %s", resp.Code, resp.Body)
+	}
+
+	resp = performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 with a valid key, got %d %s", resp.Code, resp.Body)
+	}
+
+	resp = performRequest(s.router, http.MethodGet, "/healthz")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected health check to stay open, got %d", resp.Code)
+	}
+}
+
+func TestAPIKeyAllowedInCORSPreflight(t *testing.T) {
+	s, _ := newAPIKeyTestServer(t, APIKeyEntry{Name: "web", Hash: HashAPIKey("k1")})
+
+	header := http.Header{}
+	header.Set("Origin", "https://app.example.com")
+	header.Set("Access-Control-Request-Method", http.MethodGet)
+	header.Set("Access-Control-Request-Headers", HeaderAPIKey)
+	resp := requestFrom(s, http.MethodOptions, "/v1/ip?address=1.1.1.1", "203.0.113.1:1234", header)
+
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("expected preflight to succeed, got %d", resp.Code)
+	}
+	if allowed := resp.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(strings.ToLower(allowed), strings.ToLower(HeaderAPIKey)) {
+		t.Fatalf("expected %s to be allowed, got %q", HeaderAPIKey, allowed)
+	}
+}
+
+func TestAPIKeyQuotaResetsWithPeriod(t *testing.T) {
+	s, clock := newAPIKeyTestServer(t, APIKeyEntry{Name: "billing", Hash: HashAPIKey("k1"), Quota: 2, QuotaPeriod: "1h"})
+
+	for i := 0; i < 2; i++ {
+		if resp := performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1"); resp.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, resp.Code)
+		}
+	}
+
+	clock.now = clock.now.Add(20 * time.Minute)
+	resp := performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1")
+	if resp.Code != http.StatusTooManyRequests || errorCode(t, resp.Body.Bytes()) != ErrCodeQuotaExceeded {
+		t.Fatalf("expected 429 quota_exceeded, got %d %s", resp.Code, resp.Body)
+	}
+	if got := resp.Header().Get("Retry-After"); got != "2400" {
+		t.Fatalf("expected Retry-After 2400, got %q", got)
+	}
+
+	clock.now = clock.now.Add(40 * time.Minute)
+	if resp := performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1"); resp.Code != http.StatusOK {
+		t.Fatalf("expected quota to reset, got %d", resp.Code)
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	s, clock := newAPIKeyTestServer(t, APIKeyEntry{Name: "billing", Hash: HashAPIKey("k1"), RateLimit: 1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if resp := performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1"); resp.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, resp.Code)
+		}
+	}
+
+	resp := performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1")
+	if resp.Code != http.StatusTooManyRequests || errorCode(t, resp.Body.Bytes()) != ErrCodeRateLimited {
+		t.Fatalf("expected 429 rate_limited, got %d %s", resp.Code, resp.Body)
+	}
+	if got := resp.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("expected Retry-After 1, got %q", got)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if resp := performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1"); resp.Code != http.StatusOK {
+		t.Fatalf("expected a token after one second, got %d", resp.Code)
+	}
+}
+
+func TestAPIKeyUsageEndpoint(t *testing.T) {
+	s, _ := newAPIKeyTestServer(t,
+		APIKeyEntry{Name: "search", Hash: "sha256:" + HashAPIKey("k2")},
+		APIKeyEntry{Name: "billing", Hash: HashAPIKey("k1"), Quota: 1},
+	)
+
+	performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1")
+	performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k1")
+	performRequestWithHeader(s.router, http.MethodGet, "/ip?address=1.1.1.1", HeaderAPIKey, "k2")
+
+	resp := performRequest(s.router, http.MethodGet, "/admin/keys/usage")
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected usage to require admin credentials, got %d", resp.Code)
+	}
+
+	resp = performRequestWithHeader(s.router, http.MethodGet, "/admin/keys/usage", "Authorization", "Bearer admin")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", resp.Code, resp.Body)
+	}
+
+	var payload struct {
+		Data []APIKeyUsage `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode usage: %v", err)
+	}
+	if len(payload.Data) != 2 || payload.Data[0].Name != "billing" || payload.Data[1].Name != "search" {
+		t.Fatalf("unexpected usage: %+v", payload.Data)
+	}
+	billing := payload.Data[0]
+	if billing.Requests != 1 || billing.QuotaUsed != 1 || billing.QuotaRejected != 1 {
+		t.Fatalf("unexpected billing usage: %+v", billing)
+	}
+	if payload.Data[1].Requests != 1 {
+		t.Fatalf("unexpected search usage: %+v", payload.Data[1])
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "keys.yaml")
+	yamlBody := "keys:\n  - name: billing\n    hash: " + HashAPIKey("k1") + "\n    quota: 10\n    quota_period: 1h\n"
+	if err := os.WriteFile(yamlPath, []byte(yamlBody), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := loadAPIKeys(yamlPath)
+	if err != nil {
+		t.Fatalf("load yaml: %v", err)
+	}
+	if state, ok := store.lookup("k1"); !ok || state.quota != 10 || state.period != time.Hour {
+		t.Fatalf("unexpected key state: %+v", state)
+	}
+
+	jsonPath := filepath.Join(dir, "keys.json")
+	jsonBody := `{"keys":[{"name":"billing","hash":"` + strings.ToUpper(HashAPIKey("k1")) + `"}]}`
+	if err := os.WriteFile(jsonPath, []byte(jsonBody), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err = loadAPIKeys(jsonPath)
+	if err != nil {
+		t.Fatalf("load json: %v", err)
+	}
+	if state, ok := store.lookup("k1"); !ok || state.period != defaultQuotaPeriod {
+		t.Fatalf("unexpected key state: %+v", state)
+	}
+
+	badPath := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(badPath, []byte("keys:\n  - name: billing\n    hash: k1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAPIKeys(badPath); err == nil {
+		t.Fatalf("expected an error for a plaintext key")
+	}
+}
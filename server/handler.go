@@ -3,10 +3,10 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/thiagozs/geolocation-go/models"
@@ -15,30 +15,280 @@ import (
 )
 
 func (s *Server) MaxMindHandler(c *gin.Context) {
+	s.respondLookup(c, false)
+}
+
+func (s *Server) CallerLookupHandler(c *gin.Context) {
+	s.respondLookup(c, true)
+}
+
+func (s *Server) V1LookupHandler(c *gin.Context) {
+	s.respondV1Lookup(c, false)
+}
+
+func (s *Server) V1CallerLookupHandler(c *gin.Context) {
+	s.respondV1Lookup(c, true)
+}
+
+func (s *Server) respondLookup(c *gin.Context, caller bool) {
+	record, ok := s.lookupRequest(c, caller)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": record})
+}
+
+func (s *Server) respondV1Lookup(c *gin.Context, caller bool) {
+	record, ok := s.lookupRequest(c, caller)
+	if !ok {
+		return
+	}
+
+	switch r := record.(type) {
+	case models.FullRecord:
+		c.JSON(http.StatusOK, gin.H{"data": models.NewFullLookupResponse(r)})
+	case models.Record:
+		c.JSON(http.StatusOK, gin.H{"data": models.NewLookupResponse(r)})
+	}
+}
+
+// lookupRequest validates the lookup query and resolves it into either a
+// models.Record or a models.FullRecord. The requesting client is looked up
+// when caller is set or no address was given. Error responses are written
+// here and ok is false when the caller has nothing left to do.
+func (s *Server) lookupRequest(c *gin.Context, caller bool) (interface{}, bool) {
 	var req models.Request
 	if err := c.ShouldBind(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "missing address parameter"})
-		return
+		return nil, false
 	}
 
 	addr := strings.TrimSpace(req.Address)
-	if addr == "" || !utils.IsValidIPAddress(addr) {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid ip address"})
-		return
+	if caller || addr == "" {
+		clientIP := s.clientIP(c)
+		if clientIP == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "could not determine client ip address"})
+			return nil, false
+		}
+		addr = clientIP.String()
+	}
+
+	if !utils.IsValidIPAddress(addr) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid ip address", "code": ErrCodeInvalidAddress})
+		return nil, false
+	}
+
+	format := strings.ToLower(strings.TrimSpace(req.Format))
+	if format != "" && format != FormatCompact && format != FormatFull {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "format must be compact or full"})
+		return nil, false
 	}
 
 	ip := net.ParseIP(addr)
-	record, err := s.geoIP.Lookup(ip)
+	localize := s.localizer(c, req.Lang)
+
+	var (
+		record interface{}
+		err    error
+	)
+	if format == FormatFull {
+		var full models.FullRecord
+		full, err = s.geoIP.LookupFull(ip)
+		full.MapNames(localize)
+		record = full
+	} else {
+		var compact models.Record
+		compact, err = s.geoIP.Lookup(ip)
+		compact.MapNames(localize)
+		record = compact
+	}
+	observeLookup(err)
 	if err != nil {
-		if errors.Is(err, services.ErrMaxMindDatabaseMissing) {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"message": "database not loaded"})
-			return
+		status, code, message := lookupFailure(err)
+		body := gin.H{"message": message, "code": code}
+
+		var lookupErr *services.LookupError
+		if errors.As(err, &lookupErr) {
+			if lookupErr.Network != "" {
+				body["network"] = lookupErr.Network
+				body["prefix_length"] = lookupErr.PrefixLength
+			}
+			if lookupErr.Classification != "" {
+				body["classification"] = lookupErr.Classification
+			}
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+
+		c.JSON(status, body)
+		return nil, false
+	}
+
+	return record, true
+}
+
+// lookupFailure maps a lookup error to its HTTP status, machine-readable
+// error code and client-facing message.
+func lookupFailure(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, services.ErrDatabaseMissing):
+		return http.StatusServiceUnavailable, ErrCodeDatabaseUnavailable, "database not loaded"
+	case errors.Is(err, services.ErrAddressNotFound):
+		return http.StatusNotFound, ErrCodeNotFound, services.ErrAddressNotFound.Error()
+	case errors.Is(err, services.ErrReservedAddress):
+		return http.StatusUnprocessableEntity, ErrCodeReservedAddress, services.ErrReservedAddress.Error()
+	case errors.Is(err, services.ErrUnsupportedIPVersion):
+		return http.StatusBadRequest, ErrCodeUnsupportedIPVersion, services.ErrUnsupportedIPVersion.Error()
+	case errors.Is(err, services.ErrInvalidAddress):
+		return http.StatusBadRequest, ErrCodeInvalidAddress, "invalid ip address"
+	default:
+		return http.StatusInternalServerError, ErrCodeLookupFailed, err.Error()
+	}
+}
+
+func (s *Server) BatchLookupHandler(c *gin.Context) {
+	addresses, ok := s.batchRequest(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": record})
+	localize := s.localizer(c, c.Query("lang"))
+	results := make([]models.BatchResult, 0, len(addresses))
+	for _, raw := range addresses {
+		results = append(results, s.lookupBatchItem(raw, localize))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+func (s *Server) V1BatchLookupHandler(c *gin.Context) {
+	addresses, ok := s.batchRequest(c)
+	if !ok {
+		return
+	}
+
+	localize := s.localizer(c, c.Query("lang"))
+	results := make([]models.BatchLookupResponse, 0, len(addresses))
+	for _, raw := range addresses {
+		item := s.lookupBatchItem(raw, localize)
+		result := models.BatchLookupResponse{
+			Address: item.Address,
+			Error:   item.Error,
+		}
+		if item.Data != nil {
+			data := models.NewLookupResponse(*item.Data)
+			result.Data = &data
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+// batchBytesPerAddress bounds the body of a batch request: room for the
+// longest textual IPv6 address, quotes, separator and some whitespace.
+const batchBytesPerAddress = 64
+
+func (s *Server) batchRequest(c *gin.Context) ([]string, bool) {
+	maxSize := s.maxBatchSize()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxSize+1)*batchBytesPerAddress)
+
+	var addresses []string
+	if err := c.ShouldBindJSON(&addresses); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": fmt.Sprintf("batch exceeds maximum of %d addresses", maxSize)})
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"message": "body must be a json array of addresses"})
+		return nil, false
+	}
+
+	if len(addresses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "no addresses provided"})
+		return nil, false
+	}
+
+	if len(addresses) > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": fmt.Sprintf("batch exceeds maximum of %d addresses", maxSize)})
+		return nil, false
+	}
+
+	return addresses, true
+}
+
+func (s *Server) lookupBatchItem(raw string, localize func(map[string]string) map[string]string) models.BatchResult {
+	addr := strings.TrimSpace(raw)
+	result := models.BatchResult{Address: addr}
+
+	if addr == "" || !utils.IsValidIPAddress(addr) {
+		result.Error = &models.BatchError{Code: ErrCodeInvalidAddress, Message: "invalid ip address"}
+		return result
+	}
+
+	record, err := s.geoIP.Lookup(net.ParseIP(addr))
+	observeLookup(err)
+	if err != nil {
+		_, code, message := lookupFailure(err)
+		result.Error = &models.BatchError{Code: code, Message: message}
+
+		var lookupErr *services.LookupError
+		if errors.As(err, &lookupErr) {
+			result.Error.Classification = lookupErr.Classification
+		}
+		return result
+	}
+
+	record.MapNames(localize)
+	result.Data = &record
+	return result
+}
+
+// localizer returns the names transform for a request. The lang parameter
+// wins over Accept-Language; lang=all, or no preference at all, keeps every
+// locale as decoded from the database.
+func (s *Server) localizer(c *gin.Context, lang string) func(map[string]string) map[string]string {
+	lang = strings.TrimSpace(lang)
+
+	var requested []string
+	switch {
+	case strings.EqualFold(lang, utils.LocaleAll):
+		requested = nil
+	case lang != "":
+		requested = strings.Split(lang, ",")
+	default:
+		requested = utils.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	}
+
+	if len(requested) == 0 {
+		return func(names map[string]string) map[string]string { return names }
+	}
+
+	chain := utils.LocaleChain(requested, s.fallbackLocales())
+	return func(names map[string]string) map[string]string {
+		return utils.SelectName(names, chain)
+	}
+}
+
+func (s *Server) clientIP(c *gin.Context) net.IP {
+	resolver := s.clientIPs
+	if resolver == nil {
+		resolver = &utils.ClientIPResolver{}
+	}
+	return resolver.Resolve(c.Request)
+}
+
+func (s *Server) fallbackLocales() []string {
+	if len(s.cfg.FallbackLocales) == 0 {
+		return DefaultFallbackLocales
+	}
+	return s.cfg.FallbackLocales
+}
+
+func (s *Server) maxBatchSize() int {
+	if s.cfg.MaxBatchSize <= 0 {
+		return DefaultMaxBatchSize
+	}
+	return s.cfg.MaxBatchSize
 }
 
 func (s *Server) Healthz(c *gin.Context) {
@@ -60,17 +310,9 @@ func (s *Server) DownloaderMaxMind(c *gin.Context) {
 	}
 
 	force := strings.EqualFold(c.Query("force"), "true")
-	timeout := s.cfg.GeoIP.HTTPTimeout
-	if timeout <= 0 {
-		timeout = 30 * time.Second
-	}
 
-	ctx := c.Request.Context()
-	if timeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, timeout)
-		defer cancel()
-	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), s.updateTimeout())
+	defer cancel()
 
 	status, err := s.geoIP.Update(ctx, force)
 	if err != nil {
@@ -78,7 +320,22 @@ func (s *Server) DownloaderMaxMind(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "missing maxmind license key"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, services.ErrUpdateNotSupported) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+
+		// editions are updated independently, so report the ones that did
+		// update next to the failure
+		body := gin.H{"error": err.Error()}
+		if len(status.Editions) > 0 {
+			body["editions"] = status.Editions
+		}
+		if errors.Is(err, utils.ErrChecksumMismatch) || errors.Is(err, utils.ErrInvalidDatabase) {
+			c.JSON(http.StatusBadGateway, body)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, body)
 		return
 	}
 
@@ -92,8 +349,94 @@ func (s *Server) DownloaderMaxMind(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"update":  status.Updated,
-		"file":    s.geoIP.DatabasePath(),
-		"message": message,
+		"update":   status.Updated,
+		"file":     s.geoIP.DatabasePath(),
+		"message":  message,
+		"editions": status.Editions,
 	})
 }
+
+func (s *Server) DatabaseInfo(c *gin.Context) {
+	describer, ok := s.geoIP.(databaseDescriber)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "database info not supported"})
+		return
+	}
+
+	infos := describer.DatabaseInfo()
+	if len(infos) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": services.ErrDatabaseMissing.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": infos})
+}
+
+func (s *Server) DatabaseGenerations(c *gin.Context) {
+	keeper, ok := s.geoIP.(generationKeeper)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": utils.ErrGenerationsDisabled.Error()})
+		return
+	}
+
+	generations, err := keeper.Generations()
+	if err != nil {
+		if errors.Is(err, utils.ErrGenerationsDisabled) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if generations == nil {
+		generations = []utils.Generation{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": generations})
+}
+
+func (s *Server) RollbackDatabase(c *gin.Context) {
+	keeper, ok := s.geoIP.(generationKeeper)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": utils.ErrGenerationsDisabled.Error()})
+		return
+	}
+
+	id := strings.TrimSpace(c.Query("generation"))
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing generation parameter"})
+		return
+	}
+
+	generation, err := keeper.Rollback(strings.TrimSpace(c.Query("edition")), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrGenerationNotFound), errors.Is(err, services.ErrUnknownEdition):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, utils.ErrGenerationsDisabled):
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		case errors.Is(err, utils.ErrInvalidDatabase):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "database rolled back", "data": generation})
+}
+
+func (s *Server) SchedulerStatus(c *gin.Context) {
+	if s.scheduler == nil {
+		c.JSON(http.StatusOK, gin.H{"data": SchedulerStatus{Enabled: false}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": s.scheduler.Status()})
+}
+
+func (s *Server) CacheStatus(c *gin.Context) {
+	stats := services.CacheStats{}
+	if reporter, ok := s.geoIP.(cacheReporter); ok {
+		stats = reporter.CacheStats()
+	}
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thiagozs/geolocation-go/pkg/metrics"
+	"github.com/thiagozs/geolocation-go/services"
+)
+
+// requestMetrics records the count and latency of every request, labeled
+// with the route pattern so path parameters do not explode cardinality.
+// standardMethods are kept as metric labels; any other method a client sends
+// is counted as "other" so it cannot create new series.
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+func requestMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		method := c.Request.Method
+		if !standardMethods[method] {
+			method = "other"
+		}
+		metrics.HTTPRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, method).Observe(time.Since(started).Seconds())
+	}
+}
+
+func observeLookup(err error) {
+	switch {
+	case err == nil:
+		metrics.Lookups.WithLabelValues(metrics.LookupFound).Inc()
+	case errors.Is(err, services.ErrAddressNotFound), errors.Is(err, services.ErrReservedAddress):
+		metrics.Lookups.WithLabelValues(metrics.LookupNotFound).Inc()
+	default:
+		metrics.Lookups.WithLabelValues(metrics.LookupError).Inc()
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thiagozs/geolocation-go/models"
+	"github.com/thiagozs/geolocation-go/pkg/metrics"
+	"github.com/thiagozs/geolocation-go/services"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := newTestServer(t, &fakeGeoIP{record: models.Record{IP: "1.1.1.1"}, ready: true})
+
+	if resp := performRequest(s.router, http.MethodGet, "/ip?address=1.1.1.1"); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+
+	resp := performRequest(s.router, http.MethodGet, "/metrics")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+
+	body := resp.Body.String()
+	for _, want := range []string{
+		`geolocation_http_requests_total{method="GET",route="/ip",status="200"}`,
+		`geolocation_http_request_duration_seconds_bucket{method="GET",route="/ip"`,
+		`geolocation_lookups_total{outcome="found"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in metrics output", want)
+		}
+	}
+}
+
+func TestRequestMetricsFoldsUnknownMethods(t *testing.T) {
+	s := newTestServer(t, &fakeGeoIP{})
+
+	other := metrics.HTTPRequests.WithLabelValues("unmatched", "other", "404")
+	before := testutil.ToFloat64(other)
+	for _, method := range []string{"X0", "X1", "X2"} {
+		performRequest(s.router, method, "/nowhere")
+	}
+
+	if got := testutil.ToFloat64(other); got != before+3 {
+		t.Fatalf("expected unknown methods to be counted as other, got %v", got-before)
+	}
+	if body := performRequest(s.router, http.MethodGet, "/metrics").Body.String(); strings.Contains(body, `method="X0"`) {
+		t.Fatalf("expected no series for a client-chosen method")
+	}
+}
+
+func TestObserveLookupOutcomes(t *testing.T) {
+	tests := []struct {
+		err     error
+		outcome string
+	}{
+		{nil, metrics.LookupFound},
+		{&services.LookupError{Err: services.ErrAddressNotFound}, metrics.LookupNotFound},
+		{&services.LookupError{Err: services.ErrReservedAddress}, metrics.LookupNotFound},
+		{services.ErrDatabaseMissing, metrics.LookupError},
+	}
+
+	for _, tt := range tests {
+		counter := metrics.Lookups.WithLabelValues(tt.outcome)
+		before := testutil.ToFloat64(counter)
+		observeLookup(tt.err)
+		if got := testutil.ToFloat64(counter); got != before+1 {
+			t.Fatalf("%v: expected %s to be incremented", tt.err, tt.outcome)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+
+	// idle buckets are dropped once they have refilled, at most this often
+	rateLimitSweepInterval = time.Minute
+)
+
+// RateLimitConfig limits requests per second for each client address and for
+// each API key, with bursts of up to the matching burst. A zero rate disables
+// that limit; a zero burst defaults to the rate rounded up. Admin routes take
+// no API key, so only the address limit applies to them.
+type RateLimitConfig struct {
+	PerIP    float64
+	IPBurst  int
+	PerKey   float64
+	KeyBurst int
+}
+
+// tokenBucket allows bursts of up to burst requests and refills at rate
+// tokens per second.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// bucketState is the outcome of a take: whether the request is allowed, the
+// tokens left, the time until the next token when it is not, and the time
+// until the bucket is full again.
+type bucketState struct {
+	allowed   bool
+	limit     int
+	remaining int
+	wait      time.Duration
+	reset     time.Duration
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// take consumes a token when one is available.
+func (b *tokenBucket) take(now time.Time) bucketState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	state := bucketState{limit: int(b.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		state.allowed = true
+	} else {
+		state.wait = b.until(1)
+	}
+	state.remaining = int(b.tokens)
+	state.reset = b.until(b.burst)
+	return state
+}
+
+// full reports whether the bucket has refilled, so forgetting it changes
+// nothing for the client.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) until(tokens float64) time.Duration {
+	if b.tokens >= tokens {
+		return 0
+	}
+	return time.Duration((tokens - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter keeps one token bucket per key.
+type rateLimiter struct {
+	rate  float64
+	burst int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns nil when rate is not positive; a nil limiter allows
+// everything.
+func newRateLimiter(rate float64, burst int, now func() time.Time) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     burst,
+		now:       now,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: now(),
+	}
+}
+
+func (l *rateLimiter) take(key string) bucketState {
+	now := l.now()
+
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		for k, bucket := range l.buckets {
+			if bucket.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(l.rate, l.burst, now)
+		l.buckets[key] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.take(now)
+}
+
+func (l *rateLimiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// rateLimit answers 429 once the bucket for key(c) is empty. An empty key is
+// not limited. Responses on limited routes carry RateLimit-* headers; when
+// several limiters apply, the headers describe the one with the fewest
+// requests left.
+func rateLimit(limiter *rateLimiter, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+
+		state := limiter.take(k)
+		setRateLimitHeaders(c, state)
+		if !state.allowed {
+			c.Header("Retry-After", retryAfter(state.wait))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "rate limit exceeded", "code": ErrCodeRateLimited})
+			return
+		}
+		c.Next()
+	}
+}
+
+func setRateLimitHeaders(c *gin.Context, state bucketState) {
+	header := c.Writer.Header()
+	if current := header.Get(HeaderRateLimitRemaining); current != "" {
+		if remaining, err := strconv.Atoi(current); err == nil && remaining < state.remaining {
+			return
+		}
+	}
+	header.Set(HeaderRateLimitLimit, strconv.Itoa(state.limit))
+	header.Set(HeaderRateLimitRemaining, strconv.Itoa(state.remaining))
+	header.Set(HeaderRateLimitReset, strconv.Itoa(int(math.Ceil(state.reset.Seconds()))))
+}
+
+func (s *Server) clientIPKey(c *gin.Context) string {
+	if ip := s.clientIP(c); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func apiKeyName(c *gin.Context) string {
+	return c.GetString(apiKeyContextKey)
+}
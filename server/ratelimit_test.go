@@ -0,0 +1,185 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/geolocation-go/models"
+)
+
+func newRateLimitTestServer(t *testing.T, cfg Config) (*Server, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := newTestServer(t, &fakeGeoIP{record: models.Record{IP: "1.1.1.1"}})
+	s.now = clock.Now
+	s.cfg.LookupRateLimit = cfg.LookupRateLimit
+	s.cfg.AdminRateLimit = cfg.AdminRateLimit
+	s.cfg.Admin = AdminConfig{Token: "admin"}
+	s.RegisterRoutes()
+	return s, clock
+}
+
+func requestFrom(s *Server, method, target, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remoteAddr
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(0, 0)
+	bucket := newTokenBucket(2, 3, start)
+
+	for i := 0; i < 3; i++ {
+		if state := bucket.take(start); !state.allowed || state.remaining != 2-i {
+			t.Fatalf("take %d: unexpected state %+v", i, state)
+		}
+	}
+
+	state := bucket.take(start)
+	if state.allowed || state.wait != 500*time.Millisecond || state.reset != 1500*time.Millisecond {
+		t.Fatalf("expected empty bucket, got %+v", state)
+	}
+
+	if state := bucket.take(start.Add(500 * time.Millisecond)); !state.allowed || state.remaining != 0 {
+		t.Fatalf("expected a refilled token, got %+v", state)
+	}
+	if !bucket.full(start.Add(5 * time.Second)) {
+		t.Fatalf("expected bucket to refill")
+	}
+}
+
+func TestRateLimiterDropsIdleBuckets(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := newRateLimiter(1, 1, clock.Now)
+
+	limiter.take("a")
+	limiter.take("b")
+	if limiter.size() != 2 {
+		t.Fatalf("expected 2 buckets, got %d", limiter.size())
+	}
+
+	clock.now = clock.now.Add(2 * rateLimitSweepInterval)
+	limiter.take("c")
+	if limiter.size() != 1 {
+		t.Fatalf("expected idle buckets to be dropped, got %d", limiter.size())
+	}
+
+	if newRateLimiter(0, 10, clock.Now) != nil {
+		t.Fatalf("expected a zero rate to disable the limiter")
+	}
+}
+
+func TestLookupRateLimitPerIP(t *testing.T) {
+	s, clock := newRateLimitTestServer(t, Config{LookupRateLimit: RateLimitConfig{PerIP: 1, IPBurst: 2}})
+
+	for i := 0; i < 2; i++ {
+		resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, resp.Code)
+		}
+		if got := resp.Header().Get(HeaderRateLimitRemaining); got != []string{"1", "0"}[i] {
+			t.Fatalf("request %d: unexpected RateLimit-Remaining %q", i, got)
+		}
+	}
+
+	resp := requestFrom(s, http.MethodGet, "/v1/ip?address=1.1.1.1", "203.0.113.1:1234", nil)
+	if resp.Code != http.StatusTooManyRequests || errorCode(t, resp.Body.Bytes()) != ErrCodeRateLimited {
+		t.Fatalf("expected 429 shared across /ip and /v1/ip, got %d %s", resp.Code, resp.Body)
+	}
+	if resp.Header().Get("Retry-After") != "1" || resp.Header().Get(HeaderRateLimitLimit) != "2" || resp.Header().Get(HeaderRateLimitReset) != "2" {
+		t.Fatalf("unexpected headers: %v", resp.Header())
+	}
+
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.2:1234", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected another address to have its own bucket, got %d", resp.Code)
+	}
+	if resp := requestFrom(s, http.MethodGet, "/healthz", "203.0.113.1:1234", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected health check not to be limited, got %d", resp.Code)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected a token after one second, got %d", resp.Code)
+	}
+}
+
+func TestLookupRateLimitPerKey(t *testing.T) {
+	s, _ := newRateLimitTestServer(t, Config{LookupRateLimit: RateLimitConfig{PerKey: 1, KeyBurst: 1}})
+	store, err := newAPIKeyStore([]APIKeyEntry{
+		{Name: "billing", Hash: HashAPIKey("k1")},
+		{Name: "search", Hash: HashAPIKey("k2")},
+	}, s.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.apiKeys = store
+
+	header := http.Header{}
+	header.Set(HeaderAPIKey, "k1")
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", header); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.2:1234", header); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the key to be limited across addresses, got %d", resp.Code)
+	}
+
+	header.Set(HeaderAPIKey, "k2")
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", header); resp.Code != http.StatusOK {
+		t.Fatalf("expected another key to have its own bucket, got %d", resp.Code)
+	}
+}
+
+func TestLookupRateLimitPerKeyDoesNotChargeQuota(t *testing.T) {
+	s, clock := newRateLimitTestServer(t, Config{LookupRateLimit: RateLimitConfig{PerKey: 1, KeyBurst: 1}})
+	store, err := newAPIKeyStore([]APIKeyEntry{{Name: "billing", Hash: HashAPIKey("k1"), Quota: 2}}, s.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.apiKeys = store
+
+	header := http.Header{}
+	header.Set(HeaderAPIKey, "k1")
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", header); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", header); resp.Code != http.StatusTooManyRequests || errorCode(t, resp.Body.Bytes()) != ErrCodeRateLimited {
+			t.Fatalf("request %d: expected rate limit, got %d %s", i, resp.Code, resp.Body)
+		}
+	}
+
+	if usage := store.usage()[0]; usage.QuotaUsed != 1 || usage.Requests != 1 {
+		t.Fatalf("expected rate limited requests not to use quota, got %+v", usage)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", header); resp.Code != http.StatusOK {
+		t.Fatalf("expected quota left after the limit refills, got %d %s", resp.Code, resp.Body)
+	}
+}
+
+func TestAdminRateLimitIsSeparate(t *testing.T) {
+	s, _ := newRateLimitTestServer(t, Config{
+		LookupRateLimit: RateLimitConfig{PerIP: 1, IPBurst: 1},
+		AdminRateLimit:  RateLimitConfig{PerIP: 1, IPBurst: 1},
+	})
+
+	header := http.Header{"Authorization": []string{"Bearer admin"}}
+	if resp := requestFrom(s, http.MethodPost, "/admin/db/update", "203.0.113.1:1234", header); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", resp.Code, resp.Body)
+	}
+	if resp := requestFrom(s, http.MethodPost, "/admin/db/update", "203.0.113.1:1234", header); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected admin limit, got %d", resp.Code)
+	}
+	if resp := requestFrom(s, http.MethodGet, "/ip?address=1.1.1.1", "203.0.113.1:1234", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected lookup budget to be untouched, got %d", resp.Code)
+	}
+}
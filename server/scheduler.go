@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRetryBackoff = time.Minute
+
+type SchedulerConfig struct {
+	Interval     time.Duration
+	Jitter       time.Duration
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+}
+
+type SchedulerStatus struct {
+	Enabled             bool      `json:"enabled"`
+	Interval            string    `json:"interval,omitempty"`
+	LastRun             time.Time `json:"last_run,omitempty"`
+	NextRun             time.Time `json:"next_run,omitempty"`
+	LastUpdated         bool      `json:"last_updated"`
+	LastReason          string    `json:"last_reason,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+type updateScheduler struct {
+	mu      sync.Mutex
+	svc     GeoIPService
+	cfg     SchedulerConfig
+	timeout time.Duration
+	log     *logrus.Entry
+	status  SchedulerStatus
+	jitter  func(time.Duration) time.Duration
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newUpdateScheduler(svc GeoIPService, cfg SchedulerConfig, timeout time.Duration, log *logrus.Entry) *updateScheduler {
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = cfg.Interval
+	}
+
+	return &updateScheduler{
+		svc:     svc,
+		cfg:     cfg,
+		timeout: timeout,
+		log:     log,
+		jitter:  randomJitter,
+		status: SchedulerStatus{
+			Enabled:  true,
+			Interval: cfg.Interval.String(),
+		},
+	}
+}
+
+func (u *updateScheduler) Start() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+	u.done = make(chan struct{})
+
+	go u.loop(ctx, u.done)
+}
+
+// Stop cancels the loop and waits for an in-flight update to return.
+func (u *updateScheduler) Stop() {
+	u.mu.Lock()
+	cancel, done := u.cancel, u.done
+	u.cancel, u.done = nil, nil
+	u.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+func (u *updateScheduler) Status() SchedulerStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status
+}
+
+func (u *updateScheduler) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	timer := time.NewTimer(u.scheduleNext())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			u.runOnce(ctx)
+			timer.Reset(u.scheduleNext())
+		}
+	}
+}
+
+func (u *updateScheduler) runOnce(ctx context.Context) {
+	runCtx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	started := time.Now()
+	status, err := u.svc.Update(runCtx, false)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.status.LastRun = started
+	if err != nil {
+		u.status.LastError = err.Error()
+		u.status.ConsecutiveFailures++
+		u.log.WithError(err).WithField("failures", u.status.ConsecutiveFailures).Warn("scheduled database update failed")
+		return
+	}
+
+	u.status.LastError = ""
+	u.status.ConsecutiveFailures = 0
+	u.status.LastUpdated = status.Updated
+	u.status.LastReason = status.Reason
+	u.log.WithField("updated", status.Updated).WithField("reason", status.Reason).Info("scheduled database update finished")
+}
+
+func (u *updateScheduler) scheduleNext() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delay := u.nextDelay(u.status.ConsecutiveFailures)
+	u.status.NextRun = time.Now().Add(delay)
+	return delay
+}
+
+// nextDelay returns the regular interval after a success and an exponential
+// backoff, capped at MaxBackoff, after consecutive failures. Jitter is added
+// in both cases so replicas do not hit MaxMind at the same time.
+func (u *updateScheduler) nextDelay(failures int) time.Duration {
+	delay := u.cfg.Interval
+	if failures > 0 {
+		delay = u.cfg.RetryBackoff
+		for i := 1; i < failures && delay < u.cfg.MaxBackoff; i++ {
+			delay *= 2
+		}
+		if delay > u.cfg.MaxBackoff {
+			delay = u.cfg.MaxBackoff
+		}
+	}
+
+	if u.cfg.Jitter > 0 {
+		delay += u.jitter(u.cfg.Jitter)
+	}
+	return delay
+}
+
+func randomJitter(max time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(max)))
+}
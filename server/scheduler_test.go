@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/services"
+)
+
+func newTestScheduler(svc GeoIPService, cfg SchedulerConfig) *updateScheduler {
+	scheduler := newUpdateScheduler(svc, cfg, time.Second, logrus.NewEntry(logrus.New()))
+	scheduler.jitter = func(time.Duration) time.Duration { return 0 }
+	return scheduler
+}
+
+func TestSchedulerNextDelayBacksOff(t *testing.T) {
+	scheduler := newTestScheduler(&fakeGeoIP{}, SchedulerConfig{
+		Interval:     time.Hour,
+		RetryBackoff: time.Minute,
+		MaxBackoff:   5 * time.Minute,
+	})
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Hour},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute},
+		{10, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := scheduler.nextDelay(tt.failures); got != tt.want {
+			t.Fatalf("failures=%d: expected %s, got %s", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestSchedulerNextDelayAddsJitter(t *testing.T) {
+	scheduler := newTestScheduler(&fakeGeoIP{}, SchedulerConfig{
+		Interval: time.Hour,
+		Jitter:   time.Minute,
+	})
+	scheduler.jitter = func(max time.Duration) time.Duration { return max / 2 }
+
+	if got := scheduler.nextDelay(0); got != time.Hour+30*time.Second {
+		t.Fatalf("expected jitter to be added, got %s", got)
+	}
+}
+
+func TestSchedulerRunOnceRecordsStatus(t *testing.T) {
+	svc := &fakeGeoIP{updateErr: errors.New("download failed")}
+	scheduler := newTestScheduler(svc, SchedulerConfig{Interval: time.Hour})
+
+	scheduler.runOnce(context.Background())
+	scheduler.runOnce(context.Background())
+
+	status := scheduler.Status()
+	if status.LastError != "download failed" || status.ConsecutiveFailures != 2 {
+		t.Fatalf("expected two recorded failures, got %+v", status)
+	}
+
+	svc.updateErr = nil
+	svc.updateStatus = services.UpdateStatus{Updated: true, Reason: "remote checksum changed"}
+	scheduler.runOnce(context.Background())
+
+	status = scheduler.Status()
+	if status.LastError != "" || status.ConsecutiveFailures != 0 || !status.LastUpdated {
+		t.Fatalf("expected success to reset failures, got %+v", status)
+	}
+
+	if len(svc.updateCalls) != 3 || svc.updateCalls[0] {
+		t.Fatalf("expected three non-forced update calls, got %v", svc.updateCalls)
+	}
+}
+
+func TestSchedulerStartStop(t *testing.T) {
+	svc := &fakeGeoIP{updateStatus: services.UpdateStatus{Reason: "database already up to date"}}
+	scheduler := newTestScheduler(svc, SchedulerConfig{Interval: 10 * time.Millisecond})
+
+	scheduler.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for scheduler.Status().LastRun.IsZero() {
+		if time.Now().After(deadline) {
+			scheduler.Stop()
+			t.Fatalf("scheduler did not run within deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	scheduler.Stop()
+	scheduler.Stop()
+
+	if len(svc.updateCalls) == 0 {
+		t.Fatalf("expected update to be called")
+	}
+
+	if scheduler.Status().NextRun.IsZero() {
+		t.Fatalf("expected next run to be reported")
+	}
+}
+
+func TestSchedulerStatusHandler(t *testing.T) {
+	s := newTestServer(t, &fakeGeoIP{})
+
+	resp := performRequest(s.router, http.MethodGet, "/scheduler")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data SchedulerStatus `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if payload.Data.Enabled {
+		t.Fatalf("expected scheduler to be reported as disabled")
+	}
+
+	s.scheduler = newTestScheduler(&fakeGeoIP{}, SchedulerConfig{Interval: time.Hour})
+	resp = performRequest(s.router, http.MethodGet, "/scheduler")
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if !payload.Data.Enabled || payload.Data.Interval != "1h0m0s" {
+		t.Fatalf("unexpected scheduler status: %+v", payload.Data)
+	}
+}
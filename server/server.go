@@ -13,31 +13,86 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/thiagozs/geolocation-go/models"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
 	"github.com/thiagozs/geolocation-go/services"
 )
 
+const DefaultMaxBatchSize = 100
+
+var DefaultFallbackLocales = []string{"en"}
+
+const (
+	FormatCompact = "compact"
+	FormatFull    = "full"
+)
+
+const (
+	ErrCodeInvalidAddress       = "invalid_address"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeReservedAddress      = "reserved_address"
+	ErrCodeUnsupportedIPVersion = "unsupported_ip_version"
+	ErrCodeDatabaseUnavailable  = "database_unavailable"
+	ErrCodeLookupFailed         = "lookup_failed"
+)
+
 type GeoIPService interface {
 	Lookup(net.IP) (models.Record, error)
+	LookupFull(net.IP) (models.FullRecord, error)
 	Update(context.Context, bool) (services.UpdateStatus, error)
 	Ready() bool
 	DatabasePath() string
 	Close() error
 }
 
+// cacheReporter is implemented by services that keep a lookup cache.
+type cacheReporter interface {
+	CacheStats() services.CacheStats
+}
+
+// databaseDescriber is implemented by services that can report the
+// databases they have loaded.
+type databaseDescriber interface {
+	DatabaseInfo() []services.DatabaseInfo
+}
+
+// generationKeeper is implemented by services that keep previous database
+// versions for rollback.
+type generationKeeper interface {
+	Generations() ([]utils.Generation, error)
+	Rollback(edition, id string) (utils.Generation, error)
+}
+
 type Config struct {
-	HTTPPort int
-	Mode     string
-	GeoIP    services.MaxMindConfig
+	HTTPPort        int
+	Mode            string
+	MaxBatchSize    int
+	FallbackLocales []string
+	TrustedProxies  []string
+	Scheduler       SchedulerConfig
+	Admin           AdminConfig
+	APIKeysPath     string
+	LookupRateLimit RateLimitConfig
+	AdminRateLimit  RateLimitConfig
+	Providers       []string
+	GeoIP           services.MaxMindConfig
+	MMDB            services.MMDBConfig
+	MMDBPaths       map[string]string
+	MMDBFields      map[string]map[string]string
 }
 
 type Server struct {
-	cfg    Config
-	http   *http.Server
-	router *gin.Engine
-	geoIP  GeoIPService
-	log    *logrus.Entry
+	cfg       Config
+	http      *http.Server
+	router    *gin.Engine
+	geoIP     GeoIPService
+	scheduler *updateScheduler
+	clientIPs *utils.ClientIPResolver
+	apiKeys   *apiKeyStore
+	now       func() time.Time
+	log       *logrus.Entry
 }
 
 func NewServer(cfg Config) (*Server, error) {
@@ -47,16 +102,43 @@ func NewServer(cfg Config) (*Server, error) {
 	serverLogger := logrus.NewEntry(logger).WithField("component", "server")
 	geoLogger := logrus.NewEntry(logger).WithField("component", "geoip")
 
-	geoSvc, err := services.NewMaxMindService(geoLogger, cfg.GeoIP)
+	clientIPs, err := utils.NewClientIPResolver(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("parse trusted proxies: %w", err)
+	}
+
+	var apiKeys *apiKeyStore
+	if cfg.APIKeysPath != "" {
+		apiKeys, err = loadAPIKeys(cfg.APIKeysPath)
+		if err != nil {
+			return nil, fmt.Errorf("load api keys: %w", err)
+		}
+	}
+
+	geoSvc, err := services.NewProviderChain(cfg.Providers, geoLogger, services.ProviderConfig{
+		MaxMind:    cfg.GeoIP,
+		MMDB:       cfg.MMDB,
+		MMDBPaths:  cfg.MMDBPaths,
+		MMDBFields: cfg.MMDBFields,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{
-		cfg:   cfg,
-		geoIP: geoSvc,
-		log:   serverLogger,
-	}, nil
+	srv := &Server{
+		cfg:       cfg,
+		geoIP:     geoSvc,
+		clientIPs: clientIPs,
+		apiKeys:   apiKeys,
+		log:       serverLogger,
+	}
+
+	if cfg.Scheduler.Interval > 0 {
+		schedulerLogger := logrus.NewEntry(logger).WithField("component", "scheduler")
+		srv.scheduler = newUpdateScheduler(geoSvc, cfg.Scheduler, srv.updateTimeout(), schedulerLogger)
+	}
+
+	return srv, nil
 }
 
 func (s *Server) RegisterRoutes() {
@@ -64,17 +146,62 @@ func (s *Server) RegisterRoutes() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// cors.Default, plus the API key header so browsers can send it
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AddAllowHeaders(HeaderAPIKey)
+
 	router := gin.New()
-	router.Use(gin.Recovery(), cors.Default())
+	router.Use(gin.Recovery(), cors.New(corsConfig), requestMetrics())
+
+	// The address limit runs before key checks so guessing keys is limited
+	// too; the key limit needs the key name set by apiKeyAuth and runs before
+	// the quota so rejected requests are not charged to it.
+	lookupLimits := s.cfg.LookupRateLimit
+	lookupChain := []gin.HandlerFunc{
+		rateLimit(newRateLimiter(lookupLimits.PerIP, lookupLimits.IPBurst, s.clock), s.clientIPKey),
+		s.apiKeyAuth(),
+		rateLimit(newRateLimiter(lookupLimits.PerKey, lookupLimits.KeyBurst, s.clock), apiKeyName),
+		s.apiKeyQuota(),
+	}
+	adminLimit := rateLimit(newRateLimiter(s.cfg.AdminRateLimit.PerIP, s.cfg.AdminRateLimit.IPBurst, s.clock), s.clientIPKey)
+	adminAuth := s.adminAuth()
 
-	router.GET("/ip", s.MaxMindHandler)
+	lookup := router.Group("", lookupChain...)
+	lookup.GET("/ip", s.MaxMindHandler)
+	lookup.GET("/ip/me", s.CallerLookupHandler)
+	lookup.POST("/ip/batch", s.BatchLookupHandler)
 	router.GET("/healthz", s.Healthz)
 	router.GET("/readiness", s.Readiness)
-	router.GET("/updatedb", s.DownloaderMaxMind)
+	if !s.cfg.Admin.DisableLegacyUpdate {
+		router.GET("/updatedb", adminLimit, adminAuth, s.DownloaderMaxMind)
+	}
+	router.GET("/scheduler", s.SchedulerStatus)
+	router.GET("/cache", s.CacheStatus)
+	router.GET("/db/info", s.DatabaseInfo)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	v1 := router.Group("/v1", lookupChain...)
+	v1.GET("/ip", s.V1LookupHandler)
+	v1.GET("/ip/me", s.V1CallerLookupHandler)
+	v1.POST("/ip/batch", s.V1BatchLookupHandler)
+
+	admin := router.Group("/admin", adminLimit, adminAuth)
+	admin.POST("/db/update", s.DownloaderMaxMind)
+	admin.GET("/db/generations", s.DatabaseGenerations)
+	admin.POST("/db/rollback", s.RollbackDatabase)
+	admin.GET("/keys/usage", s.APIKeyUsage)
 
 	s.router = router
 }
 
+func (s *Server) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
 func (s *Server) RegisterHTTP() {
 	if s.router == nil {
 		s.RegisterRoutes()
@@ -102,6 +229,10 @@ func (s *Server) Run() error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	if s.scheduler != nil {
+		s.scheduler.Start()
+	}
+
 	go func() {
 		if err := s.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			s.log.WithError(err).Error("http server failure")
@@ -115,11 +246,22 @@ func (s *Server) Run() error {
 	return nil
 }
 
+func (s *Server) updateTimeout() time.Duration {
+	if s.cfg.GeoIP.HTTPTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return s.cfg.GeoIP.HTTPTimeout
+}
+
 func (s *Server) gracefulShutdown() {
 	s.log.Info("shutting down server")
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	if s.scheduler != nil {
+		s.scheduler.Stop()
+	}
+
 	if err := s.geoIP.Close(); err != nil {
 		s.log.WithError(err).Warn("could not close maxmind database")
 	}
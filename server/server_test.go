@@ -4,20 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/thiagozs/geolocation-go/models"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
 	"github.com/thiagozs/geolocation-go/services"
 )
 
 type fakeGeoIP struct {
 	record       models.Record
+	fullRecord   models.FullRecord
+	fullCalls    int
 	lookupErr    error
 	ready        bool
 	dbPath       string
@@ -36,12 +42,18 @@ func (f *fakeGeoIP) Lookup(ip net.IP) (models.Record, error) {
 	return f.record, nil
 }
 
+func (f *fakeGeoIP) LookupFull(ip net.IP) (models.FullRecord, error) {
+	f.lastLookupIP = ip
+	f.fullCalls++
+	if f.lookupErr != nil {
+		return models.FullRecord{}, f.lookupErr
+	}
+	return f.fullRecord, nil
+}
+
 func (f *fakeGeoIP) Update(_ context.Context, force bool) (services.UpdateStatus, error) {
 	f.updateCalls = append(f.updateCalls, force)
-	if f.updateErr != nil {
-		return services.UpdateStatus{}, f.updateErr
-	}
-	return f.updateStatus, nil
+	return f.updateStatus, f.updateErr
 }
 
 func (f *fakeGeoIP) Ready() bool {
@@ -85,6 +97,22 @@ func performRequest(router http.Handler, method, path string) *httptest.Response
 	return rec
 }
 
+func performRequestWithHeader(router http.Handler, method, path, header, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(header, value)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func performJSONRequest(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
 func TestHealthz(t *testing.T) {
 	s := newTestServer(t, &fakeGeoIP{})
 	resp := performRequest(s.router, http.MethodGet, "/healthz")
@@ -127,8 +155,8 @@ func TestMaxMindHandlerValidation(t *testing.T) {
 		path       string
 		wantStatus int
 	}{
-		{"missing address", "/ip", http.StatusBadRequest},
 		{"invalid address", "/ip?address=not-an-ip", http.StatusBadRequest},
+		{"invalid format", "/ip?address=1.1.1.1&format=xml", http.StatusBadRequest},
 	}
 
 	for _, tt := range tests {
@@ -170,14 +198,245 @@ func TestMaxMindHandlerSuccess(t *testing.T) {
 	}
 }
 
+func TestMaxMindHandlerResolvesCaller(t *testing.T) {
+	svc := &fakeGeoIP{ready: true}
+	s := newTestServer(t, svc)
+
+	resolver, err := utils.NewClientIPResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.clientIPs = resolver
+
+	tests := []struct {
+		name   string
+		path   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"missing address uses remote addr", "/ip", "203.0.113.7:5555", "", "203.0.113.7"},
+		{"me ignores address parameter", "/ip/me?address=8.8.8.8", "203.0.113.7:5555", "", "203.0.113.7"},
+		{"trusted proxy forwards client", "/ip/me", "10.1.2.3:80", "1.1.1.1", "1.1.1.1"},
+		{"untrusted peer cannot spoof", "/v1/ip/me", "203.0.113.7:5555", "1.1.1.1", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			resp := httptest.NewRecorder()
+			s.router.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", resp.Code)
+			}
+
+			if svc.lastLookupIP.String() != tt.want {
+				t.Fatalf("expected lookup of %s, got %s", tt.want, svc.lastLookupIP)
+			}
+		})
+	}
+}
+
+func TestMaxMindHandlerFullFormat(t *testing.T) {
+	full := models.FullRecord{IP: "1.1.1.1"}
+	full.Continent.Code = "OC"
+	full.Subdivisions = []models.SubdivisionRecord{{ISOCode: "NSW"}}
+	svc := &fakeGeoIP{
+		fullRecord: full,
+		ready:      true,
+	}
+	s := newTestServer(t, svc)
+
+	resp := performRequest(s.router, http.MethodGet, "/ip?address=1.1.1.1&format=full")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data models.FullRecord `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if svc.fullCalls != 1 {
+		t.Fatalf("expected full lookup to be used, got %d calls", svc.fullCalls)
+	}
+
+	if payload.Data.Continent.Code != "OC" || len(payload.Data.Subdivisions) != 1 {
+		t.Fatalf("unexpected full record: %+v", payload.Data)
+	}
+}
+
+func TestV1LookupHandlerSnakeCase(t *testing.T) {
+	record := models.Record{IP: "1.1.1.1", AutonomousSystemNumber: 13335}
+	record.Country.ISOCode = "AU"
+	record.Country.IsInEuropeanUnion = false
+	record.Location.TimeZone = "Australia/Sydney"
+	svc := &fakeGeoIP{
+		record: record,
+		ready:  true,
+	}
+	s := newTestServer(t, svc)
+
+	resp := performRequest(s.router, http.MethodGet, "/v1/ip?address=1.1.1.1")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	country, _ := payload.Data["country"].(map[string]interface{})
+	if country["iso_code"] != "AU" {
+		t.Fatalf("expected country.iso_code AU, got %v", payload.Data["country"])
+	}
+
+	if _, ok := country["is_in_european_union"]; !ok {
+		t.Fatalf("expected is_in_european_union key, got %v", country)
+	}
+
+	location, _ := payload.Data["location"].(map[string]interface{})
+	if location["time_zone"] != "Australia/Sydney" {
+		t.Fatalf("expected location.time_zone, got %v", payload.Data["location"])
+	}
+
+	asn, _ := payload.Data["asn"].(map[string]interface{})
+	if asn["number"] != float64(13335) {
+		t.Fatalf("expected asn.number 13335, got %v", payload.Data["asn"])
+	}
+
+	if payload.Data["ip"] != "1.1.1.1" {
+		t.Fatalf("expected ip key, got %v", payload.Data)
+	}
+
+	if _, ok := payload.Data["continent"]; ok {
+		t.Fatalf("expected compact response to omit continent")
+	}
+}
+
+func TestV1LookupHandlerFullFormat(t *testing.T) {
+	full := models.FullRecord{IP: "1.1.1.1"}
+	full.Continent.Code = "OC"
+	full.Subdivisions = []models.SubdivisionRecord{{ISOCode: "NSW"}}
+	full.Sources = map[string]string{"continent": "maxmind", "subdivisions": "dbip"}
+	s := newTestServer(t, &fakeGeoIP{fullRecord: full, ready: true})
+
+	resp := performRequest(s.router, http.MethodGet, "/v1/ip?address=1.1.1.1&format=full")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data models.LookupResponse `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if payload.Data.Continent == nil || payload.Data.Continent.Code != "OC" {
+		t.Fatalf("expected continent in full response, got %+v", payload.Data.Continent)
+	}
+
+	if len(payload.Data.Subdivisions) != 1 || payload.Data.Subdivisions[0].ISOCode != "NSW" {
+		t.Fatalf("expected subdivisions in full response, got %+v", payload.Data.Subdivisions)
+	}
+
+	if payload.Data.Sources["subdivisions"] != "dbip" {
+		t.Fatalf("expected sources in full response, got %v", payload.Data.Sources)
+	}
+}
+
+func TestV1BatchLookupHandler(t *testing.T) {
+	s := newTestServer(t, &fakeGeoIP{record: models.Record{IP: "1.1.1.1"}, ready: true})
+
+	resp := performJSONRequest(s.router, http.MethodPost, "/v1/ip/batch", `["1.1.1.1", "bogus"]`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data []models.BatchLookupResponse `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if len(payload.Data) != 2 || payload.Data[0].Data == nil || payload.Data[0].Data.IP != "1.1.1.1" {
+		t.Fatalf("unexpected batch response: %+v", payload.Data)
+	}
+
+	if payload.Data[1].Error == nil || payload.Data[1].Error.Code != ErrCodeInvalidAddress {
+		t.Fatalf("expected invalid_address error, got %+v", payload.Data[1])
+	}
+}
+
+func TestMaxMindHandlerLocaleNegotiation(t *testing.T) {
+	record := models.Record{IP: "1.1.1.1"}
+	record.City.Names = map[string]string{
+		"en":    "Munich",
+		"de":    "München",
+		"pt-BR": "Munique",
+	}
+	s := newTestServer(t, &fakeGeoIP{record: record, ready: true})
+	s.cfg.FallbackLocales = []string{"de"}
+
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+		want   map[string]string
+	}{
+		{"no preference keeps all", "/ip?address=1.1.1.1", "", record.City.Names},
+		{"lang all keeps all", "/ip?address=1.1.1.1&lang=all", "pt-BR", record.City.Names},
+		{"lang param", "/ip?address=1.1.1.1&lang=en", "pt-BR", map[string]string{"en": "Munich"}},
+		{"unmatched region uses fallback", "/ip?address=1.1.1.1&lang=pt-PT", "", map[string]string{"de": "München"}},
+		{"accept language", "/ip?address=1.1.1.1", "fr;q=0.9, pt-BR", map[string]string{"pt-BR": "Munique"}},
+		{"configured fallback", "/ip?address=1.1.1.1&lang=ja", "", map[string]string{"de": "München"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := performRequestWithHeader(s.router, http.MethodGet, tt.path, "Accept-Language", tt.accept)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", resp.Code)
+			}
+
+			var payload struct {
+				Data models.Record `json:"data"`
+			}
+			if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+				t.Fatalf("invalid json: %v", err)
+			}
+
+			if !reflect.DeepEqual(payload.Data.City.Names, tt.want) {
+				t.Fatalf("expected names %v, got %v", tt.want, payload.Data.City.Names)
+			}
+		})
+	}
+}
+
 func TestMaxMindHandlerErrors(t *testing.T) {
 	tests := []struct {
 		name       string
 		err        error
 		wantStatus int
+		wantCode   string
 	}{
-		{"database missing", services.ErrMaxMindDatabaseMissing, http.StatusServiceUnavailable},
-		{"unexpected error", errors.New("boom"), http.StatusInternalServerError},
+		{"database missing", services.ErrMaxMindDatabaseMissing, http.StatusServiceUnavailable, ErrCodeDatabaseUnavailable},
+		{"not found", &services.LookupError{IP: "8.8.8.8", Err: services.ErrAddressNotFound}, http.StatusNotFound, ErrCodeNotFound},
+		{"reserved address", &services.LookupError{IP: "8.8.8.8", Err: services.ErrReservedAddress}, http.StatusUnprocessableEntity, ErrCodeReservedAddress},
+		{"unsupported ip version", &services.LookupError{IP: "8.8.8.8", Err: services.ErrUnsupportedIPVersion}, http.StatusBadRequest, ErrCodeUnsupportedIPVersion},
+		{"unexpected error", errors.New("boom"), http.StatusInternalServerError, ErrCodeLookupFailed},
 	}
 
 	for _, tt := range tests {
@@ -192,13 +451,178 @@ func TestMaxMindHandlerErrors(t *testing.T) {
 			if resp.Code != tt.wantStatus {
 				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.Code)
 			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid json: %v", err)
+			}
+
+			if body["code"] != tt.wantCode {
+				t.Fatalf("expected code %s, got %v", tt.wantCode, body["code"])
+			}
 		})
 	}
 }
 
+func TestMaxMindHandlerNotFoundReportsNetwork(t *testing.T) {
+	svc := &fakeGeoIP{
+		lookupErr: &services.LookupError{
+			IP:             "8.8.8.8",
+			Network:        "8.0.0.0/5",
+			PrefixLength:   5,
+			Classification: "public",
+			Err:            services.ErrAddressNotFound,
+		},
+	}
+	s := newTestServer(t, svc)
+
+	resp := performRequest(s.router, http.MethodGet, "/v1/ip?address=8.8.8.8")
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.Code)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if body["network"] != "8.0.0.0/5" || body["prefix_length"] != float64(5) {
+		t.Fatalf("expected network details in error body, got %v", body)
+	}
+
+	if body["classification"] != "public" {
+		t.Fatalf("expected classification in error body, got %v", body)
+	}
+}
+
+func TestBatchLookupHandlerReportsClassification(t *testing.T) {
+	svc := &fakeGeoIP{
+		lookupErr: &services.LookupError{
+			IP:             "10.0.0.1",
+			Classification: "private",
+			Err:            services.ErrReservedAddress,
+		},
+	}
+	s := newTestServer(t, svc)
+
+	resp := performJSONRequest(s.router, http.MethodPost, "/ip/batch", `["10.0.0.1"]`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data []models.BatchResult `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if len(payload.Data) != 1 || payload.Data[0].Error == nil {
+		t.Fatalf("expected a single failed item, got %+v", payload.Data)
+	}
+
+	if payload.Data[0].Error.Code != ErrCodeReservedAddress || payload.Data[0].Error.Classification != "private" {
+		t.Fatalf("unexpected batch error: %+v", payload.Data[0].Error)
+	}
+}
+
+func TestBatchLookupHandler(t *testing.T) {
+	svc := &fakeGeoIP{
+		record: models.Record{IP: "1.1.1.1"},
+		ready:  true,
+	}
+	s := newTestServer(t, svc)
+
+	resp := performJSONRequest(s.router, http.MethodPost, "/ip/batch", `["1.1.1.1", "not-an-ip"]`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data []models.BatchResult `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if len(payload.Data) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(payload.Data))
+	}
+
+	if payload.Data[0].Error != nil || payload.Data[0].Data == nil || payload.Data[0].Data.IP != "1.1.1.1" {
+		t.Fatalf("unexpected first result: %+v", payload.Data[0])
+	}
+
+	if payload.Data[1].Error == nil || payload.Data[1].Error.Code != ErrCodeInvalidAddress {
+		t.Fatalf("expected invalid_address error for second result, got %+v", payload.Data[1])
+	}
+}
+
+func TestBatchLookupHandlerLookupErrors(t *testing.T) {
+	svc := &fakeGeoIP{
+		lookupErr: services.ErrMaxMindDatabaseMissing,
+	}
+	s := newTestServer(t, svc)
+
+	resp := performJSONRequest(s.router, http.MethodPost, "/ip/batch", `["8.8.8.8"]`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data []models.BatchResult `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if len(payload.Data) != 1 || payload.Data[0].Error == nil || payload.Data[0].Error.Code != ErrCodeDatabaseUnavailable {
+		t.Fatalf("expected database_unavailable error, got %+v", payload.Data)
+	}
+}
+
+func TestBatchLookupHandlerValidation(t *testing.T) {
+	s := newTestServer(t, &fakeGeoIP{})
+	s.cfg.MaxBatchSize = 2
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"invalid json", `{"address": "1.1.1.1"}`, http.StatusBadRequest},
+		{"empty batch", `[]`, http.StatusBadRequest},
+		{"too many addresses", `["1.1.1.1", "2.2.2.2", "3.3.3.3"]`, http.StatusRequestEntityTooLarge},
+		{"oversized body", `["` + strings.Repeat("1", 1<<20) + `"]`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := performJSONRequest(s.router, http.MethodPost, "/ip/batch", tt.body)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.Code)
+			}
+		})
+	}
+}
+
+// newUpdateTestServer configures an admin token for the legacy /updatedb
+// route, which performUpdateRequest sends.
+func newUpdateTestServer(t *testing.T, svc GeoIPService) *Server {
+	t.Helper()
+	s := newTestServer(t, svc)
+	s.cfg.Admin = AdminConfig{Token: "s3cret"}
+	s.RegisterRoutes()
+	return s
+}
+
+func performUpdateRequest(s *Server, path string) *httptest.ResponseRecorder {
+	return performRequestWithHeader(s.router, http.MethodGet, path, "Authorization", "Bearer s3cret")
+}
+
 func TestDownloaderHandlerNilService(t *testing.T) {
-	s := newTestServer(t, nil)
-	resp := performRequest(s.router, http.MethodGet, "/updatedb")
+	s := newUpdateTestServer(t, nil)
+	resp := performUpdateRequest(s, "/updatedb")
 
 	if resp.Code != http.StatusInternalServerError {
 		t.Fatalf("expected 500, got %d", resp.Code)
@@ -209,15 +633,33 @@ func TestDownloaderHandlerErrors(t *testing.T) {
 	svc := &fakeGeoIP{
 		updateErr: services.ErrMaxMindLicenseMissing,
 	}
-	s := newTestServer(t, svc)
+	s := newUpdateTestServer(t, svc)
 
-	resp := performRequest(s.router, http.MethodGet, "/updatedb")
+	resp := performUpdateRequest(s, "/updatedb")
 	if resp.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 for missing license, got %d", resp.Code)
 	}
 
+	svc.updateErr = services.ErrUpdateNotSupported
+	resp = performUpdateRequest(s, "/updatedb")
+	if resp.Code != http.StatusNotImplemented {
+		t.Fatalf("expected 501 for provider without updates, got %d", resp.Code)
+	}
+
+	svc.updateErr = fmt.Errorf("GeoLite2-City: %w", &utils.ChecksumError{EditionID: "GeoLite2-City", Expected: "aa", Actual: "bb"})
+	resp = performUpdateRequest(s, "/updatedb")
+	if resp.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 for checksum mismatch, got %d", resp.Code)
+	}
+
+	svc.updateErr = &utils.ValidationError{EditionID: "GeoLite2-City", Reason: "empty search tree"}
+	resp = performUpdateRequest(s, "/updatedb")
+	if resp.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 for invalid database, got %d", resp.Code)
+	}
+
 	svc.updateErr = errors.New("download failed")
-	resp = performRequest(s.router, http.MethodGet, "/updatedb?force=true")
+	resp = performUpdateRequest(s, "/updatedb?force=true")
 	if resp.Code != http.StatusInternalServerError {
 		t.Fatalf("expected 500 for generic error, got %d", resp.Code)
 	}
@@ -227,6 +669,36 @@ func TestDownloaderHandlerErrors(t *testing.T) {
 	}
 }
 
+func TestDownloaderHandlerReportsEditionsOnPartialFailure(t *testing.T) {
+	svc := &fakeGeoIP{
+		updateStatus: services.UpdateStatus{
+			Updated: true,
+			Editions: []services.EditionStatus{
+				{EditionID: "GeoLite2-City", Updated: true, Reason: "remote checksum changed"},
+				{EditionID: "GeoLite2-ASN", Error: "download failed"},
+			},
+		},
+		updateErr: errors.New("GeoLite2-ASN: download failed"),
+	}
+	s := newUpdateTestServer(t, svc)
+
+	resp := performUpdateRequest(s, "/updatedb")
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", resp.Code)
+	}
+
+	var body struct {
+		Error    string                   `json:"error"`
+		Editions []services.EditionStatus `json:"editions"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if body.Error == "" || len(body.Editions) != 2 || !body.Editions[0].Updated || body.Editions[1].Error == "" {
+		t.Fatalf("expected per-edition status with the error, got %s", resp.Body.String())
+	}
+}
+
 func TestDownloaderHandlerSuccess(t *testing.T) {
 	svc := &fakeGeoIP{
 		updateStatus: services.UpdateStatus{
@@ -234,9 +706,9 @@ func TestDownloaderHandlerSuccess(t *testing.T) {
 			Reason:  "remote checksum changed",
 		},
 	}
-	s := newTestServer(t, svc)
+	s := newUpdateTestServer(t, svc)
 
-	resp := performRequest(s.router, http.MethodGet, "/updatedb")
+	resp := performUpdateRequest(s, "/updatedb")
 	if resp.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", resp.Code)
 	}
@@ -254,3 +726,85 @@ func TestDownloaderHandlerSuccess(t *testing.T) {
 		t.Fatalf("unexpected message: %v", body["message"])
 	}
 }
+
+type cachingGeoIP struct {
+	fakeGeoIP
+	stats services.CacheStats
+}
+
+func (c *cachingGeoIP) CacheStats() services.CacheStats {
+	return c.stats
+}
+
+func TestCacheStatusHandler(t *testing.T) {
+	s := newTestServer(t, &fakeGeoIP{})
+
+	var payload struct {
+		Data services.CacheStats `json:"data"`
+	}
+	resp := performRequest(s.router, http.MethodGet, "/cache")
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if resp.Code != http.StatusOK || payload.Data.Enabled {
+		t.Fatalf("expected disabled cache, got %d %+v", resp.Code, payload.Data)
+	}
+
+	s = newTestServer(t, &cachingGeoIP{stats: services.CacheStats{Enabled: true, Hits: 3, Misses: 1}})
+	resp = performRequest(s.router, http.MethodGet, "/cache")
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if !payload.Data.Enabled || payload.Data.Hits != 3 || payload.Data.Misses != 1 {
+		t.Fatalf("unexpected cache stats: %+v", payload.Data)
+	}
+}
+
+type describingGeoIP struct {
+	fakeGeoIP
+	infos []services.DatabaseInfo
+}
+
+func (d *describingGeoIP) DatabaseInfo() []services.DatabaseInfo {
+	return d.infos
+}
+
+func TestDatabaseInfoHandler(t *testing.T) {
+	s := newTestServer(t, &fakeGeoIP{})
+	if resp := performRequest(s.router, http.MethodGet, "/db/info"); resp.Code != http.StatusNotImplemented {
+		t.Fatalf("expected 501 for a service without info, got %d", resp.Code)
+	}
+
+	svc := &describingGeoIP{}
+	s = newTestServer(t, svc)
+	if resp := performRequest(s.router, http.MethodGet, "/db/info"); resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a loaded database, got %d", resp.Code)
+	}
+
+	svc.infos = []services.DatabaseInfo{{
+		EditionID:    "GeoLite2-City",
+		Path:         "/data/GeoLite2-City.mmdb",
+		Checksum:     "abc123",
+		DatabaseType: "GeoLite2-City",
+		BuildEpoch:   1700000000,
+		BuildTime:    time.Unix(1700000000, 0).UTC(),
+	}}
+	resp := performRequest(s.router, http.MethodGet, "/db/info")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+
+	var payload struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if len(payload.Data) != 1 {
+		t.Fatalf("unexpected payload: %s", resp.Body)
+	}
+	info := payload.Data[0]
+	if info["database_type"] != "GeoLite2-City" || info["build_time"] != "2023-11-14T22:13:20Z" || info["checksum"] != "abc123" {
+		t.Fatalf("unexpected info: %v", info)
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"container/list"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/thiagozs/geolocation-go/models"
+)
+
+const (
+	defaultCacheSize   = 10000
+	defaultCacheShards = 16
+)
+
+type CacheStats struct {
+	Enabled   bool   `json:"enabled"`
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// lookupCache is a sharded LRU of resolved records keyed by the network they
+// were found in, so one entry answers for every address of the range. An
+// address is matched by probing the prefix lengths currently cached, longest
+// first.
+//
+// Entries are tagged with the cache generation; purge bumps it, so a lookup
+// that started against a swapped-out reader cannot store its result after the
+// purge.
+type lookupCache struct {
+	shards     []*cacheShard
+	capacity   int
+	generation atomic.Uint64
+
+	// prefix lengths present in the cache, bit n set for /n
+	v4Lengths atomic.Uint64
+	v6Lengths [3]atomic.Uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type cacheShard struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[netip.Prefix]*list.Element
+	order    *list.List
+}
+
+type cacheEntry struct {
+	prefix     netip.Prefix
+	record     models.FullRecord
+	generation uint64
+}
+
+func newLookupCache(size, shards int) *lookupCache {
+	if shards <= 0 {
+		shards = defaultCacheShards
+	}
+	if shards > size {
+		shards = size
+	}
+
+	perShard := (size + shards - 1) / shards
+	cache := &lookupCache{capacity: perShard * shards}
+	for i := 0; i < shards; i++ {
+		cache.shards = append(cache.shards, &cacheShard{
+			capacity: perShard,
+			items:    make(map[netip.Prefix]*list.Element),
+			order:    list.New(),
+		})
+	}
+	return cache
+}
+
+func (c *lookupCache) get(ip net.IP) (models.FullRecord, bool) {
+	addr, ok := cacheAddr(ip)
+	if !ok {
+		c.misses.Add(1)
+		return models.FullRecord{}, false
+	}
+
+	generation := c.generation.Load()
+	for bits := addr.BitLen(); bits >= 0; bits-- {
+		if !c.hasLength(addr.Is4(), bits) {
+			continue
+		}
+
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if record, ok := c.shardFor(prefix).get(prefix, generation); ok {
+			c.hits.Add(1)
+			return record, true
+		}
+	}
+
+	c.misses.Add(1)
+	return models.FullRecord{}, false
+}
+
+func (c *lookupCache) put(network *net.IPNet, record models.FullRecord, generation uint64) {
+	if network == nil || generation != c.generation.Load() {
+		return
+	}
+
+	addr, ok := cacheAddr(network.IP)
+	if !ok {
+		return
+	}
+	ones, _ := network.Mask.Size()
+	prefix, err := addr.Prefix(ones)
+	if err != nil {
+		return
+	}
+
+	c.setLength(addr.Is4(), ones)
+	if c.shardFor(prefix).put(prefix, record, generation) {
+		c.evictions.Add(1)
+	}
+}
+
+// purge drops every entry. It is called whenever a reader or the overlay is
+// swapped, since cached records may no longer match the data.
+func (c *lookupCache) purge() {
+	c.generation.Add(1)
+	for _, shard := range c.shards {
+		shard.clear()
+	}
+	c.v4Lengths.Store(0)
+	for i := range c.v6Lengths {
+		c.v6Lengths[i].Store(0)
+	}
+}
+
+func (c *lookupCache) stats() CacheStats {
+	entries := 0
+	for _, shard := range c.shards {
+		entries += shard.len()
+	}
+
+	return CacheStats{
+		Enabled:   true,
+		Entries:   entries,
+		Capacity:  c.capacity,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+func (c *lookupCache) hasLength(v4 bool, bits int) bool {
+	if v4 {
+		return c.v4Lengths.Load()&(1<<uint(bits)) != 0
+	}
+	return c.v6Lengths[bits/64].Load()&(1<<uint(bits%64)) != 0
+}
+
+func (c *lookupCache) setLength(v4 bool, bits int) {
+	word, bit := &c.v4Lengths, uint64(1)<<uint(bits)
+	if !v4 {
+		word, bit = &c.v6Lengths[bits/64], uint64(1)<<uint(bits%64)
+	}
+
+	for {
+		old := word.Load()
+		if old&bit != 0 || word.CompareAndSwap(old, old|bit) {
+			return
+		}
+	}
+}
+
+func (c *lookupCache) shardFor(prefix netip.Prefix) *cacheShard {
+	// FNV-1a over the address bytes and the prefix length
+	hash := uint32(2166136261)
+	for _, b := range prefix.Addr().AsSlice() {
+		hash = (hash ^ uint32(b)) * 16777619
+	}
+	hash = (hash ^ uint32(prefix.Bits())) * 16777619
+	return c.shards[hash%uint32(len(c.shards))]
+}
+
+// cacheAddr converts ip to the form used for keys: 4 bytes for IPv4 and
+// IPv4-mapped addresses, 16 bytes otherwise.
+func cacheAddr(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func (s *cacheShard) get(prefix netip.Prefix, generation uint64) (models.FullRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[prefix]
+	if !ok {
+		return models.FullRecord{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if entry.generation != generation {
+		return models.FullRecord{}, false
+	}
+
+	s.order.MoveToFront(elem)
+	return entry.record, true
+}
+
+// put stores the record and reports whether the least recently used entry had
+// to be evicted to make room.
+func (s *cacheShard) put(prefix netip.Prefix, record models.FullRecord, generation uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.items[prefix]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.record, entry.generation = record, generation
+		s.order.MoveToFront(elem)
+		return false
+	}
+
+	s.items[prefix] = s.order.PushFront(&cacheEntry{prefix: prefix, record: record, generation: generation})
+	if s.order.Len() <= s.capacity {
+		return false
+	}
+
+	oldest := s.order.Back()
+	s.order.Remove(oldest)
+	delete(s.items, oldest.Value.(*cacheEntry).prefix)
+	return true
+}
+
+func (s *cacheShard) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[netip.Prefix]*list.Element)
+	s.order.Init()
+}
+
+func (s *cacheShard) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
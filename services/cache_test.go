@@ -0,0 +1,228 @@
+package services
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/internal/mmdbtest"
+	"github.com/thiagozs/geolocation-go/models"
+)
+
+func TestLookupCacheMatchesWholeNetwork(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	if _, err := svc.Lookup(net.ParseIP("1.1.1.1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record, err := svc.Lookup(net.ParseIP("1.1.1.77"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if record.IP != "1.1.1.77" || record.Country.ISOCode != "AU" || record.Network != "1.1.1.0/24" {
+		t.Fatalf("unexpected cached record: %+v", record)
+	}
+
+	stats := svc.CacheStats()
+	if !stats.Enabled || stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLookupCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLookupCache(2, 1)
+	put := func(cidr string) {
+		_, network, _ := net.ParseCIDR(cidr)
+		cache.put(network, models.FullRecord{Network: cidr}, cache.generation.Load())
+	}
+
+	put("1.0.0.0/24")
+	put("2.0.0.0/24")
+	if _, ok := cache.get(net.ParseIP("1.0.0.1")); !ok {
+		t.Fatalf("expected hit for 1.0.0.0/24")
+	}
+	put("3.0.0.0/24")
+
+	if _, ok := cache.get(net.ParseIP("2.0.0.1")); ok {
+		t.Fatalf("expected 2.0.0.0/24 to be evicted")
+	}
+	if _, ok := cache.get(net.ParseIP("1.0.0.1")); !ok {
+		t.Fatalf("expected recently used entry to survive")
+	}
+	if stats := cache.stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLookupCacheIgnoresStaleGeneration(t *testing.T) {
+	cache := newLookupCache(10, 2)
+	generation := cache.generation.Load()
+	cache.purge()
+
+	_, network, _ := net.ParseCIDR("1.0.0.0/24")
+	cache.put(network, models.FullRecord{}, generation)
+
+	if _, ok := cache.get(net.ParseIP("1.0.0.1")); ok {
+		t.Fatalf("expected result from before the purge to be dropped")
+	}
+}
+
+func TestLookupCacheInvalidatedOnReload(t *testing.T) {
+	path := writeTestDatabase(t)
+	svc := newTestService(t, path)
+
+	if _, err := svc.Lookup(net.ParseIP("1.1.1.1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := mmdbtest.Write(path, mmdbtest.Options{}, []mmdbtest.Network{
+		{CIDR: "1.1.1.0/24", Data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "NZ"}}},
+	})
+	if err != nil {
+		t.Fatalf("rewrite database: %v", err)
+	}
+	if err := svc.primary.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if record.Country.ISOCode != "NZ" {
+		t.Fatalf("expected data from the new database, got %q", record.Country.ISOCode)
+	}
+}
+
+func TestLookupCacheSkipsNetworksSplitByOverlay(t *testing.T) {
+	svc := newOverlayService(t)
+
+	if _, err := svc.Lookup(net.ParseIP("1.1.1.1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record, err := svc.Lookup(net.ParseIP("1.1.1.200"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if record.City.Names["en"] != "Melbourne" {
+		t.Fatalf("expected overlay entry inside the cached range to apply, got %v", record.City.Names)
+	}
+}
+
+func TestLookupCacheKeysByNarrowerASNNetwork(t *testing.T) {
+	dir := t.TempDir()
+	cityPath := filepath.Join(dir, "GeoLite2-City.mmdb")
+	asnPath := filepath.Join(dir, "GeoLite2-ASN.mmdb")
+	err := mmdbtest.Write(cityPath, mmdbtest.Options{}, []mmdbtest.Network{
+		{CIDR: "1.1.0.0/16", Data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "AU"}}},
+	})
+	if err != nil {
+		t.Fatalf("write city database: %v", err)
+	}
+	err = mmdbtest.Write(asnPath, mmdbtest.Options{DatabaseType: ASNEditionID}, []mmdbtest.Network{
+		{CIDR: "1.1.1.0/24", Data: map[string]interface{}{"autonomous_system_number": uint32(13335)}},
+		{CIDR: "1.1.2.0/24", Data: map[string]interface{}{"autonomous_system_number": uint32(4444)}},
+	})
+	if err != nil {
+		t.Fatalf("write asn database: %v", err)
+	}
+
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{
+		DatabasePath:    cityPath,
+		ASNDatabasePath: asnPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating service: %v", err)
+	}
+	defer svc.Close()
+
+	for _, tt := range []struct {
+		ip      string
+		asn     uint
+		network string
+	}{
+		{"1.1.1.1", 13335, "1.1.1.0/24"},
+		{"1.1.2.2", 4444, "1.1.2.0/24"},
+		{"1.1.1.9", 13335, "1.1.1.0/24"},
+	} {
+		record, err := svc.Lookup(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.ip, err)
+		}
+		if record.AutonomousSystemNumber != tt.asn || record.Network != tt.network || record.Country.ISOCode != "AU" {
+			t.Fatalf("%s: unexpected record: %+v", tt.ip, record)
+		}
+	}
+
+	if stats := svc.CacheStats(); stats.Hits != 1 || stats.Entries != 2 {
+		t.Fatalf("expected one entry per ASN network, got %+v", stats)
+	}
+}
+
+func TestLookupCacheKeepsMaxMindDataUnderWiderOverlay(t *testing.T) {
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{
+		DatabasePath: writeTestDatabase(t),
+		OverlayPath:  writeOverlay(t, "overlay.yaml", "networks:\n  - cidr: 1.1.0.0/16\n    time_zone: Australia/Sydney\n"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating service: %v", err)
+	}
+	defer svc.Close()
+
+	outside, err := svc.Lookup(net.ParseIP("1.1.2.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outside.Country.ISOCode != "" || outside.PrefixLength <= 16 {
+		t.Fatalf("expected the overlay-only answer to keep MaxMind's narrower range, got %+v", outside)
+	}
+
+	for i := 0; i < 2; i++ {
+		record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if record.Country.ISOCode != "AU" || record.Network != "1.1.1.0/24" || record.Location.TimeZone != "Australia/Sydney" {
+			t.Fatalf("lookup %d: expected MaxMind data with the overlay, got %+v", i, record)
+		}
+	}
+}
+
+func TestLookupCacheReturnsIndependentRecords(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	first, err := svc.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first.MapNames(func(map[string]string) map[string]string { return nil })
+
+	second, err := svc.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(second.Subdivisions) == 0 || second.Subdivisions[0].Names["en"] == "" {
+		t.Fatalf("expected cached subdivisions to be unaffected, got %+v", second.Subdivisions)
+	}
+}
+
+func TestLookupCacheDisabled(t *testing.T) {
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{
+		DatabasePath: writeTestDatabase(t),
+		CacheSize:    -1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer svc.Close()
+
+	if _, err := svc.Lookup(net.ParseIP("1.1.1.1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats := svc.CacheStats(); stats.Enabled {
+		t.Fatalf("expected cache to be disabled, got %+v", stats)
+	}
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/models"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
+)
+
+// Record groups reported in FullRecord.Sources. A group is always taken from
+// a single provider, so e.g. latitude and longitude never come from different
+// datasets.
+const (
+	SourceContinent          = "continent"
+	SourceCountry            = "country"
+	SourceRegisteredCountry  = "registered_country"
+	SourceRepresentedCountry = "represented_country"
+	SourceSubdivisions       = "subdivisions"
+	SourceCity               = "city"
+	SourcePostal             = "postal"
+	SourceLocation           = "location"
+	SourceASN                = "asn"
+	SourceMetadata           = "metadata"
+)
+
+type mergeGroup struct {
+	name  string
+	empty func(record *models.FullRecord) bool
+	copy  func(dst, src *models.FullRecord)
+}
+
+var mergeGroups = []mergeGroup{
+	{
+		name:  SourceContinent,
+		empty: func(r *models.FullRecord) bool { return r.Continent.Code == "" && len(r.Continent.Names) == 0 },
+		copy:  func(dst, src *models.FullRecord) { dst.Continent = src.Continent },
+	},
+	{
+		name:  SourceCountry,
+		empty: func(r *models.FullRecord) bool { return r.Country.ISOCode == "" && len(r.Country.Names) == 0 },
+		copy:  func(dst, src *models.FullRecord) { dst.Country = src.Country },
+	},
+	{
+		name: SourceRegisteredCountry,
+		empty: func(r *models.FullRecord) bool {
+			return r.RegisteredCountry.ISOCode == "" && len(r.RegisteredCountry.Names) == 0
+		},
+		copy: func(dst, src *models.FullRecord) { dst.RegisteredCountry = src.RegisteredCountry },
+	},
+	{
+		name: SourceRepresentedCountry,
+		empty: func(r *models.FullRecord) bool {
+			return r.RepresentedCountry.ISOCode == "" && len(r.RepresentedCountry.Names) == 0
+		},
+		copy: func(dst, src *models.FullRecord) { dst.RepresentedCountry = src.RepresentedCountry },
+	},
+	{
+		name:  SourceSubdivisions,
+		empty: func(r *models.FullRecord) bool { return len(r.Subdivisions) == 0 },
+		copy:  func(dst, src *models.FullRecord) { dst.Subdivisions = src.Subdivisions },
+	},
+	{
+		name:  SourceCity,
+		empty: func(r *models.FullRecord) bool { return len(r.City.Names) == 0 },
+		copy:  func(dst, src *models.FullRecord) { dst.City = src.City },
+	},
+	{
+		name:  SourcePostal,
+		empty: func(r *models.FullRecord) bool { return r.Postal.Code == "" },
+		copy:  func(dst, src *models.FullRecord) { dst.Postal = src.Postal },
+	},
+	{
+		name: SourceLocation,
+		empty: func(r *models.FullRecord) bool {
+			return r.Location.Latitude == 0 && r.Location.Longitude == 0 && r.Location.TimeZone == ""
+		},
+		copy: func(dst, src *models.FullRecord) { dst.Location = src.Location },
+	},
+	{
+		name: SourceASN,
+		empty: func(r *models.FullRecord) bool {
+			return r.AutonomousSystemNumber == 0 && r.AutonomousSystemOrganization == ""
+		},
+		copy: func(dst, src *models.FullRecord) {
+			dst.AutonomousSystemNumber = src.AutonomousSystemNumber
+			dst.AutonomousSystemOrganization = src.AutonomousSystemOrganization
+		},
+	},
+	{
+		name:  SourceMetadata,
+		empty: func(r *models.FullRecord) bool { return len(r.Metadata) == 0 },
+		copy:  func(dst, src *models.FullRecord) { dst.Metadata = src.Metadata },
+	},
+}
+
+type namedProvider struct {
+	name     string
+	provider Provider
+}
+
+// CompositeService queries providers in order and fills the groups still
+// empty after each one from the next. The first provider is the primary: it
+// decides readiness and the reported database path.
+type CompositeService struct {
+	log       *logrus.Entry
+	providers []namedProvider
+}
+
+// NewProviderChain builds the providers registered under names, in order. A
+// single name returns that provider as is; more are wrapped in a
+// CompositeService.
+func NewProviderChain(names []string, log *logrus.Entry, cfg ProviderConfig) (Provider, error) {
+	if len(names) <= 1 {
+		name := ""
+		if len(names) == 1 {
+			name = names[0]
+		}
+		return NewProvider(name, log, cfg)
+	}
+
+	if err := checkSharedMMDBConfig(names, cfg); err != nil {
+		return nil, err
+	}
+
+	composite := &CompositeService{log: log}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			_ = composite.Close()
+			return nil, fmt.Errorf("provider %q listed more than once", name)
+		}
+		seen[name] = true
+
+		provider, err := NewProvider(name, log, cfg)
+		if err != nil {
+			_ = composite.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		composite.providers = append(composite.providers, namedProvider{name: name, provider: provider})
+	}
+
+	return composite, nil
+}
+
+// checkSharedMMDBConfig refuses a chain of several mmdb-backed providers that
+// would share a file or field overrides meant for one of them. Each of them
+// then needs its own entry in MMDBPaths, and field overrides in MMDBFields.
+func checkSharedMMDBConfig(names []string, cfg ProviderConfig) error {
+	var chained []string
+	for _, name := range names {
+		if name = strings.ToLower(strings.TrimSpace(name)); mmdbProviders[name] {
+			chained = append(chained, name)
+		}
+	}
+	if len(chained) < 2 {
+		return nil
+	}
+
+	if len(cfg.MMDB.Fields) > 0 {
+		return fmt.Errorf("mmdb field overrides are ambiguous with providers %s: set them per provider", strings.Join(chained, ", "))
+	}
+	for _, name := range chained {
+		if cfg.MMDBPaths[name] == "" {
+			return fmt.Errorf("provider %s: mmdb path must be set per provider when chaining %s", name, strings.Join(chained, ", "))
+		}
+	}
+	return nil
+}
+
+func (c *CompositeService) Lookup(ip net.IP) (models.Record, error) {
+	record, err := c.LookupFull(ip)
+	if err != nil {
+		return models.Record{}, err
+	}
+	return record.Compact(), nil
+}
+
+// LookupFull merges the providers' answers group by group. A provider that
+// fails is skipped; the primary's error is returned when none has an entry.
+func (c *CompositeService) LookupFull(ip net.IP) (models.FullRecord, error) {
+	if ip == nil {
+		return models.FullRecord{}, ErrInvalidAddress
+	}
+
+	var (
+		merged   models.FullRecord
+		firstErr error
+		found    bool
+		// the narrowest range a consulted provider reported having no data
+		// for; the merged answer may differ outside it
+		missNetwork string
+		missPrefix  int
+	)
+
+	for _, p := range c.providers {
+		record, err := p.provider.LookupFull(ip)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			var lookupErr *LookupError
+			if !errors.As(err, &lookupErr) {
+				c.log.WithError(err).WithField("provider", p.name).Warn("provider lookup failed")
+			} else if lookupErr.Network != "" && lookupErr.PrefixLength > missPrefix {
+				missNetwork, missPrefix = lookupErr.Network, lookupErr.PrefixLength
+			}
+			continue
+		}
+
+		if !found {
+			merged.IP = record.IP
+			merged.Classification = record.Classification
+			merged.Sources = map[string]string{}
+			found = true
+		}
+
+		contributed := false
+		for _, group := range mergeGroups {
+			if group.empty(&merged) && !group.empty(&record) {
+				group.copy(&merged, &record)
+				merged.Sources[group.name] = p.name
+				contributed = true
+			}
+		}
+
+		// the merged record only holds for the range every contributor covers
+		if contributed && record.PrefixLength > merged.PrefixLength {
+			merged.Network, merged.PrefixLength = record.Network, record.PrefixLength
+		}
+
+		if complete(&merged) {
+			break
+		}
+	}
+
+	if !found {
+		return models.FullRecord{}, firstErr
+	}
+
+	if missPrefix > merged.PrefixLength {
+		merged.Network, merged.PrefixLength = missNetwork, missPrefix
+	}
+	merged.Found = true
+	return merged, nil
+}
+
+func complete(record *models.FullRecord) bool {
+	for _, group := range mergeGroups {
+		if group.empty(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// Update refreshes every provider that manages its own data and reports the
+// editions of all of them together.
+func (c *CompositeService) Update(ctx context.Context, force bool) (UpdateStatus, error) {
+	var (
+		status    UpdateStatus
+		reasons   []string
+		errs      []error
+		supported bool
+	)
+
+	for _, p := range c.providers {
+		result, err := p.provider.Update(ctx, force)
+		if errors.Is(err, ErrUpdateNotSupported) {
+			continue
+		}
+		supported = true
+
+		status.Editions = append(status.Editions, result.Editions...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.name, err))
+			continue
+		}
+
+		status.Updated = status.Updated || result.Updated
+		if result.Reason != "" {
+			reasons = append(reasons, p.name+": "+result.Reason)
+		}
+	}
+
+	if !supported {
+		return UpdateStatus{}, ErrUpdateNotSupported
+	}
+
+	status.Reason = strings.Join(reasons, "; ")
+	if len(errs) > 0 {
+		return status, errors.Join(errs...)
+	}
+	return status, nil
+}
+
+// CacheStats adds up the counters of the providers that keep a cache.
+func (c *CompositeService) CacheStats() CacheStats {
+	var total CacheStats
+	for _, p := range c.providers {
+		cached, ok := p.provider.(interface{ CacheStats() CacheStats })
+		if !ok {
+			continue
+		}
+
+		stats := cached.CacheStats()
+		if !stats.Enabled {
+			continue
+		}
+		total.Enabled = true
+		total.Entries += stats.Entries
+		total.Capacity += stats.Capacity
+		total.Hits += stats.Hits
+		total.Misses += stats.Misses
+		total.Evictions += stats.Evictions
+	}
+	return total
+}
+
+// DatabaseInfo describes the databases of every provider, in chain order.
+func (c *CompositeService) DatabaseInfo() []DatabaseInfo {
+	var infos []DatabaseInfo
+	for _, p := range c.providers {
+		if describer, ok := p.provider.(interface{ DatabaseInfo() []DatabaseInfo }); ok {
+			infos = append(infos, describer.DatabaseInfo()...)
+		}
+	}
+	return infos
+}
+
+type generationKeeper interface {
+	Generations() ([]utils.Generation, error)
+	Rollback(edition, id string) (utils.Generation, error)
+}
+
+// Generations lists the kept database versions of every provider that keeps
+// them.
+func (c *CompositeService) Generations() ([]utils.Generation, error) {
+	var (
+		all       []utils.Generation
+		supported bool
+	)
+	for _, p := range c.providers {
+		keeper, ok := p.provider.(generationKeeper)
+		if !ok {
+			continue
+		}
+		supported = true
+
+		generations, err := keeper.Generations()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.name, err)
+		}
+		all = append(all, generations...)
+	}
+	if !supported {
+		return nil, utils.ErrGenerationsDisabled
+	}
+	return all, nil
+}
+
+// Rollback hands the rollback to the first provider that keeps generations
+// and knows edition.
+func (c *CompositeService) Rollback(edition, id string) (utils.Generation, error) {
+	for _, p := range c.providers {
+		keeper, ok := p.provider.(generationKeeper)
+		if !ok {
+			continue
+		}
+
+		generation, err := keeper.Rollback(edition, id)
+		if errors.Is(err, ErrUnknownEdition) {
+			continue
+		}
+		return generation, err
+	}
+	if edition != "" {
+		return utils.Generation{}, fmt.Errorf("%w: %s", ErrUnknownEdition, edition)
+	}
+	return utils.Generation{}, utils.ErrGenerationsDisabled
+}
+
+func (c *CompositeService) Ready() bool {
+	return len(c.providers) > 0 && c.providers[0].provider.Ready()
+}
+
+func (c *CompositeService) DatabasePath() string {
+	if len(c.providers) == 0 {
+		return ""
+	}
+	return c.providers[0].provider.DatabasePath()
+}
+
+func (c *CompositeService) Close() error {
+	var errs []error
+	for _, p := range c.providers {
+		if err := p.provider.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/internal/mmdbtest"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
+)
+
+func newTestChain(t *testing.T) Provider {
+	t.Helper()
+
+	chain, err := NewProviderChain([]string{ProviderMaxMind, ProviderIPinfo}, logrus.NewEntry(logrus.New()), ProviderConfig{
+		MaxMind:   MaxMindConfig{DatabasePath: writeTestDatabase(t)},
+		MMDBPaths: map[string]string{ProviderIPinfo: writeIPinfoDatabase(t)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating chain: %v", err)
+	}
+	t.Cleanup(func() { _ = chain.Close() })
+	return chain
+}
+
+func TestProviderChainMergesMissingGroups(t *testing.T) {
+	chain := newTestChain(t)
+
+	record, err := chain.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if record.City.Names["en"] != "Sydney" || record.Country.ISOCode != "AU" {
+		t.Fatalf("expected primary location, got %+v", record)
+	}
+	if record.AutonomousSystemNumber != 13335 {
+		t.Fatalf("expected asn from secondary provider, got %d", record.AutonomousSystemNumber)
+	}
+
+	want := map[string]string{
+		SourceCity:      ProviderMaxMind,
+		SourceCountry:   ProviderMaxMind,
+		SourceContinent: ProviderMaxMind,
+		SourceASN:       ProviderIPinfo,
+	}
+	for group, provider := range want {
+		if record.Sources[group] != provider {
+			t.Fatalf("expected %s from %s, got sources %v", group, provider, record.Sources)
+		}
+	}
+
+	compact, err := chain.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if compact.AutonomousSystemNumber != 13335 || compact.Sources[SourceASN] != ProviderIPinfo {
+		t.Fatalf("unexpected compact record: %+v", compact)
+	}
+}
+
+func TestProviderChainFallsBackWhenPrimaryMisses(t *testing.T) {
+	chain := newTestChain(t)
+
+	record, err := chain.Lookup(net.ParseIP("8.8.8.8"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !record.Found || record.Country.ISOCode != "US" || record.Network != "8.8.8.0/24" {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+	if record.Sources[SourceCountry] != ProviderIPinfo {
+		t.Fatalf("expected country from ipinfo, got %v", record.Sources)
+	}
+
+	_, err = chain.Lookup(net.ParseIP("9.9.9.9"))
+	if !errors.Is(err, ErrAddressNotFound) {
+		t.Fatalf("expected ErrAddressNotFound when no provider knows the address, got %v", err)
+	}
+}
+
+func TestProviderChainNarrowsToPrimaryMissRange(t *testing.T) {
+	ipinfoPath := filepath.Join(t.TempDir(), "ipinfo.mmdb")
+	err := mmdbtest.Write(ipinfoPath, mmdbtest.Options{DatabaseType: "ipinfo location"}, []mmdbtest.Network{
+		{CIDR: "1.0.0.0/8", Data: map[string]interface{}{"country_code": "US"}},
+	})
+	if err != nil {
+		t.Fatalf("write ipinfo database: %v", err)
+	}
+
+	chain, err := NewProviderChain([]string{ProviderMaxMind, ProviderIPinfo}, logrus.NewEntry(logrus.New()), ProviderConfig{
+		MaxMind:   MaxMindConfig{DatabasePath: writeTestDatabase(t)},
+		MMDBPaths: map[string]string{ProviderIPinfo: ipinfoPath},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating chain: %v", err)
+	}
+	defer chain.Close()
+
+	record, err := chain.LookupFull(net.ParseIP("1.1.2.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, network, _ := net.ParseCIDR(record.Network)
+	if record.Country.ISOCode != "US" || record.PrefixLength <= 8 || network.Contains(net.ParseIP("1.1.1.1")) {
+		t.Fatalf("expected the range maxmind has no data for, got %s (%+v)", record.Network, record)
+	}
+}
+
+func TestProviderChainUpdateSkipsStaticProviders(t *testing.T) {
+	chain := newTestChain(t)
+
+	_, err := chain.Update(context.Background(), false)
+	if !errors.Is(err, ErrMaxMindLicenseMissing) {
+		t.Fatalf("expected license error from the maxmind provider, got %v", err)
+	}
+}
+
+func TestProviderChainRollbackUsesMaxMindProvider(t *testing.T) {
+	chain := newTestChain(t).(*CompositeService)
+
+	generations, err := chain.Generations()
+	if err != nil || len(generations) != 0 {
+		t.Fatalf("expected no generations yet, got %+v, %v", generations, err)
+	}
+
+	if _, err := chain.Rollback("", "20240101T000000Z"); !errors.Is(err, utils.ErrGenerationNotFound) {
+		t.Fatalf("expected the maxmind provider to answer, got %v", err)
+	}
+	if _, err := chain.Rollback("GeoIP2-ISP", "20240101T000000Z"); !errors.Is(err, ErrUnknownEdition) {
+		t.Fatalf("expected ErrUnknownEdition, got %v", err)
+	}
+}
+
+func TestProviderChainDatabaseInfo(t *testing.T) {
+	chain := newTestChain(t).(*CompositeService)
+
+	infos := chain.DatabaseInfo()
+	if len(infos) != 2 || infos[0].EditionID != utils.DefaultEditionID || infos[1].EditionID != ProviderIPinfo {
+		t.Fatalf("expected maxmind then ipinfo, got %+v", infos)
+	}
+}
+
+func TestProviderChainRejectsDuplicates(t *testing.T) {
+	_, err := NewProviderChain([]string{ProviderMaxMind, ProviderMaxMind}, logrus.NewEntry(logrus.New()), ProviderConfig{
+		MaxMind: MaxMindConfig{DatabasePath: writeTestDatabase(t)},
+	})
+	if err == nil {
+		t.Fatalf("expected error for duplicate provider")
+	}
+}
+
+func TestProviderChainScopesMMDBFields(t *testing.T) {
+	log := logrus.NewEntry(logrus.New())
+	paths := map[string]string{
+		ProviderDBIP:   writeTestDatabase(t),
+		ProviderIPinfo: writeIPinfoDatabase(t),
+	}
+
+	if _, err := NewProviderChain([]string{ProviderDBIP, ProviderIPinfo}, log, ProviderConfig{
+		MMDB:      MMDBConfig{Fields: map[string]string{"city_names": "region"}},
+		MMDBPaths: paths,
+	}); err == nil {
+		t.Fatalf("expected shared field overrides to be refused")
+	}
+	if _, err := NewProviderChain([]string{ProviderDBIP, ProviderIPinfo}, log, ProviderConfig{
+		MMDB:      MMDBConfig{DatabasePath: paths[ProviderDBIP]},
+		MMDBPaths: map[string]string{ProviderIPinfo: paths[ProviderIPinfo]},
+	}); err == nil {
+		t.Fatalf("expected a shared path to be refused")
+	}
+
+	chain, err := NewProviderChain([]string{ProviderDBIP, ProviderIPinfo}, log, ProviderConfig{
+		MMDBPaths:  paths,
+		MMDBFields: map[string]map[string]string{ProviderIPinfo: {"city_names": "region"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating chain: %v", err)
+	}
+	defer chain.Close()
+
+	providers := chain.(*CompositeService).providers
+	dbip, err := providers[0].provider.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected dbip error: %v", err)
+	}
+	ipinfo, err := providers[1].provider.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected ipinfo error: %v", err)
+	}
+
+	if dbip.City.Names["en"] != "Sydney" {
+		t.Fatalf("expected dbip to keep its preset, got %v", dbip.City.Names)
+	}
+	if ipinfo.City.Names["en"] != "New South Wales" {
+		t.Fatalf("expected ipinfo override to apply, got %v", ipinfo.City.Names)
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import "errors"
+
+var (
+	ErrInvalidAddress       = errors.New("invalid IP address")
+	ErrAddressNotFound      = errors.New("address not found in database")
+	ErrReservedAddress      = errors.New("address belongs to a private or reserved range")
+	ErrUnsupportedIPVersion = errors.New("ip version not supported by database")
+	ErrDatabaseMissing      = errors.New("database not loaded")
+	ErrUpdateNotSupported   = errors.New("provider does not support updates")
+	ErrUnknownProvider      = errors.New("unknown geolocation provider")
+	ErrUnknownEdition       = errors.New("unknown database edition")
+)
+
+// LookupError describes why an address could not be resolved. Err is one of
+// the sentinel errors above, so callers can match it with errors.Is. Network
+// and PrefixLength are set when the database reported the range the address
+// belongs to, which lets callers cache negative answers for the whole range.
+type LookupError struct {
+	IP             string
+	Network        string
+	PrefixLength   int
+	Classification string
+	Err            error
+}
+
+func (e *LookupError) Error() string {
+	return e.IP + ": " + e.Err.Error()
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
@@ -6,38 +6,79 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/oschwald/maxminddb-golang"
 	"github.com/sirupsen/logrus"
 	"github.com/thiagozs/geolocation-go/models"
+	"github.com/thiagozs/geolocation-go/pkg/metrics"
 	"github.com/thiagozs/geolocation-go/pkg/utils"
 )
 
 var (
-	ErrMaxMindLicenseMissing  = errors.New("maxmind license key not configured")
-	ErrMaxMindDatabaseMissing = errors.New("maxmind database not loaded")
+	ErrMaxMindLicenseMissing = errors.New("maxmind license key not configured")
+	// ErrMaxMindDatabaseMissing is the MaxMind name of ErrDatabaseMissing.
+	ErrMaxMindDatabaseMissing = ErrDatabaseMissing
 )
 
+const ASNEditionID = "GeoLite2-ASN"
+
+const defaultKeepGenerations = 3
+
 type MaxMindConfig struct {
+	EditionID          string
 	DatabasePath       string
+	ASNDatabasePath    string
+	Editions           []EditionConfig
+	OverlayPath        string
+	OverlayInterval    time.Duration
+	CacheSize          int
+	CacheShards        int
 	LicenseKey         string
 	HTTPTimeout        time.Duration
 	MinRefreshInterval time.Duration
+	KeepGenerations    int
+	// WatchDatabase reloads databases replaced on disk by other tools,
+	// WatchDebounce after the last change
+	WatchDatabase bool
+	WatchDebounce time.Duration
+}
+
+// EditionConfig describes an additional MaxMind edition managed next to the
+// primary database. DatabasePath defaults to <edition>.mmdb in the directory
+// of the primary database.
+type EditionConfig struct {
+	EditionID    string
+	DatabasePath string
 }
 
 type UpdateStatus struct {
-	Updated bool
-	Reason  string
+	Updated  bool
+	Reason   string
+	Editions []EditionStatus
+}
+
+type EditionStatus struct {
+	EditionID string `json:"edition_id"`
+	Path      string `json:"path"`
+	Updated   bool   `json:"updated"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 type MaxMindService struct {
-	mu         sync.RWMutex
-	reader     *maxminddb.Reader
-	log        *logrus.Entry
-	cfg        MaxMindConfig
-	downloader *utils.DatabaseDownloader
+	log       *logrus.Entry
+	cfg       MaxMindConfig
+	primary   *database
+	asn       *database
+	databases []*database
+	overlay   *Overlay
+	watcher   *databaseWatcher
+	cache     *lookupCache
+	// updateMu keeps updates and rollbacks from replacing files concurrently
+	updateMu sync.Mutex
 }
 
 func NewMaxMindService(log *logrus.Entry, cfg MaxMindConfig) (*MaxMindService, error) {
@@ -48,127 +89,439 @@ func NewMaxMindService(log *logrus.Entry, cfg MaxMindConfig) (*MaxMindService, e
 		cfg: cfg,
 	}
 
-	if cfg.LicenseKey != "" {
-		service.downloader = utils.NewDatabaseDownloader(cfg.LicenseKey, cfg.DatabasePath, cfg.HTTPTimeout, cfg.MinRefreshInterval)
+	if cfg.CacheSize > 0 {
+		service.cache = newLookupCache(cfg.CacheSize, cfg.CacheShards)
 	}
 
-	if err := service.reloadReader(); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			if service.downloader == nil {
-				return nil, fmt.Errorf("open maxmind database: %w", err)
-			}
+	service.primary = service.newDatabase(cfg.EditionID, cfg.DatabasePath)
+	service.databases = append(service.databases, service.primary)
+	for _, edition := range cfg.Editions {
+		db := service.newDatabase(edition.EditionID, edition.DatabasePath)
+		if edition.EditionID == ASNEditionID {
+			service.asn = db
+		}
+		service.databases = append(service.databases, db)
+	}
 
-			ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTPTimeout)
-			defer cancel()
+	if err := service.openDatabase(service.primary); err != nil {
+		return nil, err
+	}
 
-			if _, _, updateErr := service.downloader.EnsureLatest(ctx, true); updateErr != nil {
-				return nil, fmt.Errorf("download maxmind database: %w", updateErr)
-			}
+	if cfg.OverlayPath != "" {
+		var onChange func()
+		if service.cache != nil {
+			onChange = service.cache.purge
+		}
+		overlay, err := NewOverlay(log, cfg.OverlayPath, cfg.OverlayInterval, onChange)
+		if err != nil {
+			_ = service.Close()
+			return nil, fmt.Errorf("load overlay: %w", err)
+		}
+		service.overlay = overlay
+	}
 
-			if err := service.reloadReader(); err != nil {
-				return nil, fmt.Errorf("open maxmind database after download: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("open maxmind database: %w", err)
+	for _, db := range service.databases[1:] {
+		if err := service.openDatabase(db); err != nil {
+			log.WithError(err).WithField("edition", db.edition).Warn("maxmind edition not available")
 		}
 	}
 
-	log.WithField("path", cfg.DatabasePath).Info("maxmind database ready")
+	if cfg.WatchDatabase {
+		watcher, err := newDatabaseWatcher(service, cfg.WatchDebounce)
+		if err != nil {
+			_ = service.Close()
+			return nil, fmt.Errorf("watch database: %w", err)
+		}
+		service.watcher = watcher
+	}
 
 	return service, nil
 }
 
 func (m *MaxMindService) Lookup(ip net.IP) (models.Record, error) {
-	if ip == nil {
-		return models.Record{}, errors.New("invalid IP address")
+	record, err := m.LookupFull(ip)
+	if err != nil {
+		return models.Record{}, err
+	}
+	return record.Compact(), nil
+}
+
+func (m *MaxMindService) LookupFull(ip net.IP) (models.FullRecord, error) {
+	if ip == nil || m.cache == nil {
+		record, _, err := m.resolve(ip)
+		return record, err
 	}
 
-	reader := m.currentReader()
-	if reader == nil {
-		return models.Record{}, ErrMaxMindDatabaseMissing
+	if record, ok := m.cache.get(ip); ok {
+		record.Subdivisions = cloneSubdivisions(record.Subdivisions)
+		record.IP = ip.String()
+		record.Classification = string(utils.ClassifyIP(ip))
+		return record, nil
 	}
 
-	var record models.Record
-	if err := reader.Lookup(ip, &record); err != nil {
-		return models.Record{}, err
+	generation := m.cache.generation.Load()
+	record, network, err := m.resolve(ip)
+	if err != nil {
+		return models.FullRecord{}, err
 	}
 
-	record.IP = ip.String()
+	if m.overlay == nil || !m.overlay.hasNestedEntry(network) {
+		cached := record
+		cached.Subdivisions = cloneSubdivisions(record.Subdivisions)
+		m.cache.put(network, cached, generation)
+	}
 	return record, nil
 }
 
+// cloneSubdivisions copies the slice a cached record shares with callers,
+// since MapNames localizes subdivisions in place.
+func cloneSubdivisions(subdivisions []models.SubdivisionRecord) []models.SubdivisionRecord {
+	if subdivisions == nil {
+		return nil
+	}
+	return append([]models.SubdivisionRecord(nil), subdivisions...)
+}
+
+// CacheStats reports the lookup cache counters; Enabled is false when the
+// cache is turned off.
+func (m *MaxMindService) CacheStats() CacheStats {
+	if m.cache == nil {
+		return CacheStats{}
+	}
+	return m.cache.stats()
+}
+
+// resolve decodes the record for ip from the databases and the overlay and
+// returns the network the answer holds for.
+func (m *MaxMindService) resolve(ip net.IP) (models.FullRecord, *net.IPNet, error) {
+	var record models.FullRecord
+	entry, overlaid := m.lookupOverlay(ip)
+
+	// without MaxMind data the network is the range MaxMind has nothing for,
+	// which the overlay entry may only narrow
+	network, err := m.lookup(ip, &record)
+	if err != nil && (!overlaid || !isMissingData(err)) {
+		return models.FullRecord{}, nil, err
+	}
+
+	if overlaid {
+		entry.applyFullRecord(&record)
+		network = narrowestNetwork(network, entry.network)
+	}
+
+	record.IP = ip.String()
+	record.Network, record.PrefixLength = describeNetwork(network)
+	record.Found = true
+	record.Classification = string(utils.ClassifyIP(ip))
+	return record, network, nil
+}
+
+// lookupOverlay consults the overlay file ahead of the MaxMind data. A match
+// is merged field by field over the MaxMind record, or stands on its own when
+// MaxMind has no entry for the address.
+func (m *MaxMindService) lookupOverlay(ip net.IP) (OverlayEntry, bool) {
+	if m.overlay == nil {
+		return OverlayEntry{}, false
+	}
+	return m.overlay.Lookup(ip)
+}
+
+// lookup decodes the primary record for ip into result, merged with the ASN
+// edition when one is configured. The returned network is the narrower of
+// the two editions' networks, since the ASN data follows ranges of its own.
+func (m *MaxMindService) lookup(ip net.IP, result interface{}) (*net.IPNet, error) {
+	network, err := m.primary.lookup(ip, result)
+	if err != nil {
+		return network, err
+	}
+
+	if asnNetwork := m.enrichASN(ip, result); asnNetwork != nil {
+		network = narrowestNetwork(network, asnNetwork)
+	}
+	return network, nil
+}
+
+func isMissingData(err error) bool {
+	var lookupErr *LookupError
+	return errors.As(err, &lookupErr)
+}
+
+// narrowestNetwork returns the more specific of network and other, which
+// must not be nil, so the reported range never spans addresses with other
+// data.
+func narrowestNetwork(network, other *net.IPNet) *net.IPNet {
+	if network == nil {
+		return other
+	}
+	networkOnes, _ := network.Mask.Size()
+	otherOnes, _ := other.Mask.Size()
+	if otherOnes > networkOnes {
+		return other
+	}
+	return network
+}
+
+func describeNetwork(network *net.IPNet) (string, int) {
+	if network == nil {
+		return "", 0
+	}
+	ones, _ := network.Mask.Size()
+	return network.String(), ones
+}
+
+// enrichASN decodes the ASN edition into the same record and returns the
+// ASN network ip belongs to, whether or not it has data. The ASN data is
+// optional, so a missing database or a failed lookup leaves the fields empty
+// and returns no network.
+func (m *MaxMindService) enrichASN(ip net.IP, result interface{}) *net.IPNet {
+	if m.asn == nil {
+		return nil
+	}
+
+	handle := m.asn.acquire()
+	if handle == nil {
+		return nil
+	}
+	defer handle.release()
+
+	network, _, err := handle.reader.LookupNetwork(ip, result)
+	if err != nil {
+		m.log.WithError(err).WithField("ip", ip.String()).Debug("asn lookup failed")
+		return nil
+	}
+	return network
+}
+
+// Update refreshes every configured edition independently. A failure in one
+// edition does not stop the others; the failures are reported per edition and
+// joined into the returned error.
 func (m *MaxMindService) Update(ctx context.Context, force bool) (UpdateStatus, error) {
-	if m.downloader == nil {
+	if m.cfg.LicenseKey == "" {
 		return UpdateStatus{}, ErrMaxMindLicenseMissing
 	}
 
-	updated, reason, err := m.downloader.EnsureLatest(ctx, force)
-	if err != nil {
-		return UpdateStatus{}, err
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+
+	var (
+		status  UpdateStatus
+		reasons []string
+		errs    []error
+	)
+
+	for _, db := range m.databases {
+		result := EditionStatus{
+			EditionID: db.edition,
+			Path:      db.path,
+		}
+
+		metrics.UpdateAttempts.WithLabelValues(db.edition).Inc()
+		updated, reason, err := m.updateDatabase(ctx, db, force)
+		if err != nil {
+			metrics.UpdateFailures.WithLabelValues(db.edition).Inc()
+			result.Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", db.edition, err))
+		} else {
+			metrics.UpdateSuccesses.WithLabelValues(db.edition).Inc()
+			result.Updated = updated
+			result.Reason = reason
+			status.Updated = status.Updated || updated
+			reasons = append(reasons, db.edition+": "+reason)
+		}
+
+		status.Editions = append(status.Editions, result)
 	}
 
-	if updated {
-		if err := m.reloadReader(); err != nil {
-			return UpdateStatus{}, err
+	if len(m.databases) == 1 {
+		status.Reason = status.Editions[0].Reason
+	} else {
+		status.Reason = strings.Join(reasons, "; ")
+	}
+
+	if len(errs) > 0 {
+		return status, errors.Join(errs...)
+	}
+	return status, nil
+}
+
+// DatabaseInfo describes every loaded edition, the primary one first.
+func (m *MaxMindService) DatabaseInfo() []DatabaseInfo {
+	var infos []DatabaseInfo
+	for _, db := range m.databases {
+		if info, ok := db.info(); ok {
+			infos = append(infos, info)
 		}
-		m.log.WithField("path", m.cfg.DatabasePath).Info("maxmind database reloaded")
 	}
+	return infos
+}
 
-	return UpdateStatus{
-		Updated: updated,
-		Reason:  reason,
-	}, nil
+// Generations lists the kept versions of every edition, newest first within
+// each edition.
+func (m *MaxMindService) Generations() ([]utils.Generation, error) {
+	var all []utils.Generation
+	for _, db := range m.databases {
+		if db.generations == nil {
+			continue
+		}
+		generations, err := db.generations.List()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", db.edition, err)
+		}
+		all = append(all, generations...)
+	}
+	return all, nil
 }
 
-func (m *MaxMindService) Close() error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// Rollback reinstalls a kept generation of edition, the primary edition when
+// empty, and reloads it.
+func (m *MaxMindService) Rollback(edition, id string) (utils.Generation, error) {
+	db := m.database(edition)
+	if db == nil {
+		return utils.Generation{}, fmt.Errorf("%w: %s", ErrUnknownEdition, edition)
+	}
+	if db.generations == nil {
+		return utils.Generation{}, utils.ErrGenerationsDisabled
+	}
 
-	if m.reader == nil {
-		return nil
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+
+	generation, err := db.generations.Rollback(id)
+	if err != nil {
+		return utils.Generation{}, err
+	}
+	if err := db.reload(); err != nil {
+		return utils.Generation{}, err
+	}
+
+	m.log.WithField("edition", db.edition).WithField("generation", id).Info("maxmind database rolled back")
+	return generation, nil
+}
+
+func (m *MaxMindService) database(edition string) *database {
+	if edition == "" {
+		return m.primary
+	}
+	for _, db := range m.databases {
+		if db.edition == edition {
+			return db
+		}
 	}
+	return nil
+}
 
-	err := m.reader.Close()
-	m.reader = nil
-	return err
+func (m *MaxMindService) Close() error {
+	if m.watcher != nil {
+		m.watcher.Close()
+	}
+	if m.overlay != nil {
+		m.overlay.Close()
+	}
+
+	var errs []error
+	for _, db := range m.databases {
+		if err := db.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (m *MaxMindService) Ready() bool {
-	return m.currentReader() != nil
+	return m.primary.ready()
 }
 
 func (m *MaxMindService) DatabasePath() string {
 	return m.cfg.DatabasePath
 }
 
-func (m *MaxMindService) currentReader() *maxminddb.Reader {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.reader
-}
+func (m *MaxMindService) newDatabase(edition, path string) *database {
+	db := &database{
+		edition: edition,
+		path:    path,
+	}
 
-func (m *MaxMindService) reloadReader() error {
-	reader, err := maxminddb.Open(m.cfg.DatabasePath)
-	if err != nil {
-		return err
+	if m.cache != nil {
+		db.onSwap = m.cache.purge
 	}
 
-	m.mu.Lock()
-	oldReader := m.reader
-	m.reader = reader
-	m.mu.Unlock()
+	if m.cfg.KeepGenerations > 0 {
+		db.generations = utils.NewGenerationStore(edition, path, m.cfg.KeepGenerations)
+	}
+
+	if m.cfg.LicenseKey != "" {
+		db.downloader = utils.NewEditionDownloader(m.cfg.LicenseKey, edition, path, m.cfg.HTTPTimeout, m.cfg.MinRefreshInterval)
+		db.downloader.Generations = db.generations
+	}
+	return db
+}
+
+func (m *MaxMindService) openDatabase(db *database) error {
+	if err := db.reload(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) || db.downloader == nil {
+			return fmt.Errorf("open maxmind database: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.cfg.HTTPTimeout)
+		defer cancel()
 
-	if oldReader != nil {
-		_ = oldReader.Close()
+		if _, _, updateErr := db.downloader.EnsureLatest(ctx, true); updateErr != nil {
+			return fmt.Errorf("download maxmind database: %w", updateErr)
+		}
+
+		if err := db.reload(); err != nil {
+			return fmt.Errorf("open maxmind database after download: %w", err)
+		}
 	}
+
+	m.log.WithField("edition", db.edition).WithField("path", db.path).Info("maxmind database ready")
 	return nil
 }
 
+func (m *MaxMindService) updateDatabase(ctx context.Context, db *database, force bool) (bool, string, error) {
+	updated, reason, err := db.downloader.EnsureLatest(ctx, force)
+	if err != nil {
+		return false, "", err
+	}
+
+	if updated {
+		if err := db.reload(); err != nil {
+			return false, "", err
+		}
+		m.log.WithField("edition", db.edition).WithField("path", db.path).Info("maxmind database reloaded")
+	}
+
+	return updated, reason, nil
+}
+
 func applyDefaults(cfg MaxMindConfig) MaxMindConfig {
+	if cfg.EditionID == "" {
+		cfg.EditionID = utils.DefaultEditionID
+	}
+
 	if cfg.DatabasePath == "" {
-		cfg.DatabasePath = "db/GeoLite2-City.mmdb"
+		cfg.DatabasePath = filepath.Join("db", cfg.EditionID+".mmdb")
+	}
+
+	if cfg.ASNDatabasePath != "" {
+		cfg.Editions = append([]EditionConfig{{EditionID: ASNEditionID, DatabasePath: cfg.ASNDatabasePath}}, cfg.Editions...)
 	}
 
+	seen := map[string]bool{cfg.EditionID: true}
+	editions := make([]EditionConfig, 0, len(cfg.Editions))
+	for _, edition := range cfg.Editions {
+		if edition.EditionID == "" || seen[edition.EditionID] {
+			continue
+		}
+		seen[edition.EditionID] = true
+
+		if edition.DatabasePath == "" {
+			edition.DatabasePath = filepath.Join(filepath.Dir(cfg.DatabasePath), edition.EditionID+".mmdb")
+		}
+		editions = append(editions, edition)
+	}
+	cfg.Editions = editions
+
 	if cfg.HTTPTimeout <= 0 {
 		cfg.HTTPTimeout = 30 * time.Second
 	}
@@ -177,5 +530,15 @@ func applyDefaults(cfg MaxMindConfig) MaxMindConfig {
 		cfg.MinRefreshInterval = 24 * time.Hour
 	}
 
+	// a negative size turns the cache off
+	if cfg.CacheSize == 0 {
+		cfg.CacheSize = defaultCacheSize
+	}
+
+	// a negative count keeps no generations
+	if cfg.KeepGenerations == 0 {
+		cfg.KeepGenerations = defaultKeepGenerations
+	}
+
 	return cfg
 }
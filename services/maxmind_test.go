@@ -2,14 +2,67 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/internal/mmdbtest"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
 )
 
+func writeTestDatabase(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	writeTestDatabaseAt(t, path)
+	return path
+}
+
+func writeTestDatabaseAt(t *testing.T, path string) {
+	t.Helper()
+
+	err := mmdbtest.Write(path, mmdbtest.Options{BuildEpoch: 1700000000}, []mmdbtest.Network{
+		{
+			CIDR: "1.1.1.0/24",
+			Data: map[string]interface{}{
+				"continent": map[string]interface{}{"code": "OC", "geoname_id": uint32(6255151)},
+				"country":   map[string]interface{}{"iso_code": "AU", "geoname_id": uint32(2077456)},
+				"city":      map[string]interface{}{"names": map[string]string{"en": "Sydney"}, "geoname_id": uint32(2147714)},
+				"subdivisions": []interface{}{
+					map[string]interface{}{"iso_code": "NSW", "names": map[string]string{"en": "New South Wales"}},
+				},
+			},
+		},
+		{
+			CIDR: "2001:db8::/32",
+			Data: map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "DE"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("write test database: %v", err)
+	}
+}
+
+func newTestService(t *testing.T, dbPath string) *MaxMindService {
+	t.Helper()
+
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{DatabasePath: dbPath})
+	if err != nil {
+		t.Fatalf("unexpected error creating service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc
+}
+
 func TestNewMaxMindServiceWithoutDatabaseOrLicenseFails(t *testing.T) {
 	log := logrus.NewEntry(logrus.New())
 	cfg := MaxMindConfig{
@@ -54,3 +107,398 @@ func TestMaxMindServiceUpdateWithoutLicense(t *testing.T) {
 		t.Fatalf("expected ErrMaxMindLicenseMissing, got %v", err)
 	}
 }
+
+func TestMaxMindServiceLookup(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+
+	if record.Country.ISOCode != "AU" || record.City.Names["en"] != "Sydney" {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+
+	if record.IP != "1.1.1.1" {
+		t.Fatalf("expected IP to be set, got %q", record.IP)
+	}
+
+	if !record.Found || record.Network != "1.1.1.0/24" || record.PrefixLength != 24 {
+		t.Fatalf("expected network 1.1.1.0/24, got found=%v network=%q prefix=%d", record.Found, record.Network, record.PrefixLength)
+	}
+
+	if record.Classification != "public" {
+		t.Fatalf("expected public classification, got %q", record.Classification)
+	}
+}
+
+func TestMaxMindServiceLookupNotFound(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	tests := []struct {
+		name    string
+		address string
+		want    error
+		class   string
+	}{
+		{"public address without entry", "8.8.8.8", ErrAddressNotFound, "public"},
+		{"private address", "10.0.0.1", ErrReservedAddress, "private"},
+		{"shared address space", "100.64.1.1", ErrReservedAddress, "cgnat"},
+		{"loopback address", "::1", ErrReservedAddress, "loopback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.Lookup(net.ParseIP(tt.address))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+
+			var lookupErr *LookupError
+			if !errors.As(err, &lookupErr) {
+				t.Fatalf("expected *LookupError, got %T", err)
+			}
+
+			if lookupErr.IP != tt.address || lookupErr.Network == "" || lookupErr.PrefixLength == 0 {
+				t.Fatalf("expected address and network details, got %+v", lookupErr)
+			}
+
+			if lookupErr.Classification != tt.class {
+				t.Fatalf("expected classification %s, got %s", tt.class, lookupErr.Classification)
+			}
+		})
+	}
+
+	full, err := svc.LookupFull(net.ParseIP("2001:db8::1"))
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+
+	if !full.Found || full.Network != "2001:db8::/32" || full.PrefixLength != 32 {
+		t.Fatalf("expected network 2001:db8::/32, got %+v", full)
+	}
+}
+
+func TestMaxMindServiceLookupInvalidAddress(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	if _, err := svc.Lookup(nil); !errors.Is(err, ErrInvalidAddress) {
+		t.Fatalf("expected ErrInvalidAddress, got %v", err)
+	}
+}
+
+func TestMaxMindServiceLookupFull(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	record, err := svc.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+
+	if record.Continent.Code != "OC" || record.City.GeoNameID != 2147714 || record.Country.GeoNameID != 2077456 {
+		t.Fatalf("unexpected full record: %+v", record)
+	}
+
+	if len(record.Subdivisions) != 1 || record.Subdivisions[0].ISOCode != "NSW" {
+		t.Fatalf("expected subdivisions to be decoded, got %+v", record.Subdivisions)
+	}
+
+	if record.IP != "1.1.1.1" {
+		t.Fatalf("expected IP to be set, got %q", record.IP)
+	}
+}
+
+func TestMaxMindServiceKeepsReaderOpenUntilReleased(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	handle := svc.primary.acquire()
+	if handle == nil {
+		t.Fatalf("expected a loaded reader")
+	}
+
+	if err := svc.primary.reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	var record map[string]interface{}
+	if err := handle.reader.Lookup(net.ParseIP("1.1.1.1"), &record); err != nil {
+		t.Fatalf("expected swapped-out reader to stay usable while referenced: %v", err)
+	}
+
+	if err := handle.release(); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+
+	if err := handle.reader.Lookup(net.ParseIP("1.1.1.1"), &record); err == nil {
+		t.Fatalf("expected reader to be closed after last reference was released")
+	}
+}
+
+func TestMaxMindServiceConcurrentLookupsDuringReload(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if record.Country.ISOCode != "AU" {
+					errs <- fmt.Errorf("unexpected country %q", record.Country.ISOCode)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := svc.primary.reload(); err != nil {
+			t.Fatalf("reload %d failed: %v", i, err)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("lookup failed during reload: %v", err)
+	}
+}
+
+func TestApplyDefaultsEditions(t *testing.T) {
+	cfg := applyDefaults(MaxMindConfig{
+		DatabasePath: filepath.Join("data", "city.mmdb"),
+		Editions: []EditionConfig{
+			{EditionID: "GeoLite2-ASN"},
+			{EditionID: "GeoLite2-Country", DatabasePath: "/srv/country.mmdb"},
+			{EditionID: "GeoLite2-City"},
+			{},
+		},
+	})
+
+	if cfg.EditionID != "GeoLite2-City" {
+		t.Fatalf("expected default edition GeoLite2-City, got %s", cfg.EditionID)
+	}
+
+	if len(cfg.Editions) != 2 {
+		t.Fatalf("expected primary and empty editions to be dropped, got %+v", cfg.Editions)
+	}
+
+	if cfg.Editions[0].DatabasePath != filepath.Join("data", "GeoLite2-ASN.mmdb") {
+		t.Fatalf("unexpected default path for ASN edition: %s", cfg.Editions[0].DatabasePath)
+	}
+
+	if cfg.Editions[1].DatabasePath != "/srv/country.mmdb" {
+		t.Fatalf("expected explicit path to be kept, got %s", cfg.Editions[1].DatabasePath)
+	}
+}
+
+func TestNewMaxMindServiceLoadsAdditionalEditions(t *testing.T) {
+	dbPath := writeTestDatabase(t)
+	countryPath := filepath.Join(filepath.Dir(dbPath), "GeoLite2-Country.mmdb")
+	writeTestDatabaseAt(t, countryPath)
+
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{
+		DatabasePath: dbPath,
+		Editions: []EditionConfig{
+			{EditionID: "GeoLite2-Country"},
+			{EditionID: "GeoLite2-ASN"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected missing additional edition not to fail startup: %v", err)
+	}
+	defer svc.Close()
+
+	if len(svc.databases) != 3 {
+		t.Fatalf("expected three managed databases, got %d", len(svc.databases))
+	}
+
+	if !svc.databases[1].ready() {
+		t.Fatalf("expected country edition to be loaded")
+	}
+
+	if svc.databases[2].ready() {
+		t.Fatalf("expected missing ASN edition not to be ready")
+	}
+
+	if !svc.Ready() {
+		t.Fatalf("expected service to be ready with the primary database loaded")
+	}
+}
+
+func TestMaxMindServiceLookupMergesASN(t *testing.T) {
+	dbPath := writeTestDatabase(t)
+	asnPath := filepath.Join(filepath.Dir(dbPath), "GeoLite2-ASN.mmdb")
+	err := mmdbtest.Write(asnPath, mmdbtest.Options{DatabaseType: ASNEditionID}, []mmdbtest.Network{
+		{
+			CIDR: "1.1.1.0/24",
+			Data: map[string]interface{}{
+				"autonomous_system_number":       uint32(13335),
+				"autonomous_system_organization": "CLOUDFLARENET",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("write asn database: %v", err)
+	}
+
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{
+		DatabasePath:    dbPath,
+		ASNDatabasePath: asnPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating service: %v", err)
+	}
+	defer svc.Close()
+
+	record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+
+	if record.AutonomousSystemNumber != 13335 || record.AutonomousSystemOrganization != "CLOUDFLARENET" {
+		t.Fatalf("expected ASN data to be merged, got %+v", record)
+	}
+
+	if record.Country.ISOCode != "AU" {
+		t.Fatalf("expected city data to be kept, got %+v", record)
+	}
+
+	record, err = svc.Lookup(net.ParseIP("2001:db8::1"))
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+
+	if record.AutonomousSystemNumber != 0 || record.Country.ISOCode != "DE" {
+		t.Fatalf("expected city data without ASN, got %+v", record)
+	}
+}
+
+func TestMaxMindServiceLookupWithoutASN(t *testing.T) {
+	svc := newTestService(t, writeTestDatabase(t))
+
+	record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+
+	if record.AutonomousSystemNumber != 0 || record.AutonomousSystemOrganization != "" {
+		t.Fatalf("expected ASN fields to be empty, got %+v", record)
+	}
+}
+
+func TestMaxMindServiceRollbackReloadsGeneration(t *testing.T) {
+	dbPath := writeTestDatabase(t)
+	svc := newTestService(t, dbPath)
+
+	if record, err := svc.Lookup(net.ParseIP("1.1.1.1")); err != nil || record.Country.ISOCode != "AU" {
+		t.Fatalf("unexpected record before rollback: %+v, %v", record, err)
+	}
+
+	// a kept generation as the downloader would have left it
+	dir := utils.NewGenerationStore(utils.DefaultEditionID, dbPath, 3).Dir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := mmdbtest.Write(filepath.Join(dir, "20240101T000000Z.mmdb"), mmdbtest.Options{BuildEpoch: 1600000000}, []mmdbtest.Network{
+		{CIDR: "1.1.1.0/24", Data: map[string]interface{}{"country": map[string]interface{}{"iso_code": "NZ"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ := json.Marshal(utils.Generation{ID: "20240101T000000Z", EditionID: utils.DefaultEditionID, Checksum: "old"})
+	if err := os.WriteFile(filepath.Join(dir, "20240101T000000Z.json"), metadata, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	generations, err := svc.Generations()
+	if err != nil || len(generations) != 1 {
+		t.Fatalf("unexpected generations: %+v, %v", generations, err)
+	}
+
+	if _, err := svc.Rollback("GeoIP2-Enterprise", "20240101T000000Z"); !errors.Is(err, ErrUnknownEdition) {
+		t.Fatalf("expected ErrUnknownEdition, got %v", err)
+	}
+
+	if _, err := svc.Rollback("", "20240101T000000Z"); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil || record.Country.ISOCode != "NZ" {
+		t.Fatalf("expected the rolled back database to serve lookups, got %+v, %v", record, err)
+	}
+}
+
+func TestMaxMindServiceRollbackWithoutGenerations(t *testing.T) {
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{DatabasePath: writeTestDatabase(t), KeepGenerations: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close()
+
+	if _, err := svc.Rollback("", "20240101T000000Z"); !errors.Is(err, utils.ErrGenerationsDisabled) {
+		t.Fatalf("expected ErrGenerationsDisabled, got %v", err)
+	}
+}
+
+func TestMaxMindServiceDatabaseInfo(t *testing.T) {
+	dbPath := writeTestDatabase(t)
+	if err := os.WriteFile(dbPath+utils.DefaultChecksumExt, []byte("abc123  GeoLite2-City_20231114.tar.gz\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	svc := newTestService(t, dbPath)
+
+	infos := svc.DatabaseInfo()
+	if len(infos) != 1 {
+		t.Fatalf("expected one database, got %+v", infos)
+	}
+
+	info := infos[0]
+	stat, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.EditionID != utils.DefaultEditionID || info.Path != dbPath || info.Size != stat.Size() {
+		t.Fatalf("unexpected file details: %+v", info)
+	}
+	if info.Checksum != "abc123  GeoLite2-City_20231114.tar.gz" {
+		t.Fatalf("unexpected checksum %q", info.Checksum)
+	}
+	if info.DatabaseType != "GeoLite2-City" || info.BuildEpoch != 1700000000 || !info.BuildTime.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected build details: %+v", info)
+	}
+	if info.IPVersion != 6 || info.RecordSize != 24 || info.NodeCount == 0 || info.Description["en"] != "test database" {
+		t.Fatalf("unexpected metadata: %+v", info)
+	}
+	if len(info.Languages) != 1 || info.Languages[0] != "en" {
+		t.Fatalf("unexpected languages: %v", info.Languages)
+	}
+	if info.LoadedAt.Before(before) {
+		t.Fatalf("expected load time after %v, got %v", before, info.LoadedAt)
+	}
+
+	loadedAt := info.LoadedAt
+	time.Sleep(time.Millisecond)
+	if err := svc.primary.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded := svc.DatabaseInfo()[0]; !reloaded.LoadedAt.After(loadedAt) {
+		t.Fatalf("expected reload to update the load time")
+	}
+}
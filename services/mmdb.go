@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/models"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
+)
+
+const defaultMMDBLanguage = "en"
+
+// GeoIP2FieldMapping reads the GeoIP2 City schema, which DB-IP Lite and most
+// MaxMind-compatible datasets follow.
+var GeoIP2FieldMapping = map[string]string{
+	"continent_code":              "continent.code",
+	"continent_names":             "continent.names",
+	"country_iso_code":            "country.iso_code",
+	"country_names":               "country.names",
+	"is_in_european_union":        "country.is_in_european_union",
+	"registered_country_iso_code": "registered_country.iso_code",
+	"registered_country_names":    "registered_country.names",
+	"subdivision_iso_code":        "subdivisions.0.iso_code",
+	"subdivision_names":           "subdivisions.0.names",
+	"city_names":                  "city.names",
+	"postal_code":                 "postal.code",
+	"latitude":                    "location.latitude",
+	"longitude":                   "location.longitude",
+	"accuracy_radius":             "location.accuracy_radius",
+	"time_zone":                   "location.time_zone",
+	"asn":                         "autonomous_system_number",
+	"as_organization":             "autonomous_system_organization",
+}
+
+// IPinfoFieldMapping reads the flat IPinfo mmdb schema. Fields a given
+// IPinfo dataset does not carry are simply left empty.
+var IPinfoFieldMapping = map[string]string{
+	"continent_code":    "continent_code",
+	"continent_names":   "continent",
+	"country_iso_code":  "country_code",
+	"country_names":     "country",
+	"subdivision_names": "region",
+	"city_names":        "city",
+	"postal_code":       "postal_code",
+	"latitude":          "latitude",
+	"longitude":         "longitude",
+	"time_zone":         "timezone",
+	"asn":               "asn",
+	"as_organization":   "as_name",
+}
+
+type fieldSetter func(record *models.FullRecord, value interface{}, lang string)
+
+// mmdbFields are the normalized fields a mapping can fill.
+var mmdbFields = map[string]fieldSetter{
+	"continent_code": func(r *models.FullRecord, v interface{}, _ string) { r.Continent.Code = asString(v) },
+	"continent_names": func(r *models.FullRecord, v interface{}, lang string) {
+		r.Continent.Names = asNames(v, lang)
+	},
+	"country_iso_code": func(r *models.FullRecord, v interface{}, _ string) { r.Country.ISOCode = asString(v) },
+	"country_names": func(r *models.FullRecord, v interface{}, lang string) {
+		r.Country.Names = asNames(v, lang)
+	},
+	"is_in_european_union": func(r *models.FullRecord, v interface{}, _ string) { r.Country.IsInEuropeanUnion = asBool(v) },
+	"registered_country_iso_code": func(r *models.FullRecord, v interface{}, _ string) {
+		r.RegisteredCountry.ISOCode = asString(v)
+	},
+	"registered_country_names": func(r *models.FullRecord, v interface{}, lang string) {
+		r.RegisteredCountry.Names = asNames(v, lang)
+	},
+	"subdivision_iso_code": func(r *models.FullRecord, v interface{}, _ string) {
+		firstSubdivision(r).ISOCode = asString(v)
+	},
+	"subdivision_names": func(r *models.FullRecord, v interface{}, lang string) {
+		firstSubdivision(r).Names = asNames(v, lang)
+	},
+	"city_names":      func(r *models.FullRecord, v interface{}, lang string) { r.City.Names = asNames(v, lang) },
+	"postal_code":     func(r *models.FullRecord, v interface{}, _ string) { r.Postal.Code = asString(v) },
+	"latitude":        func(r *models.FullRecord, v interface{}, _ string) { r.Location.Latitude = asFloat(v) },
+	"longitude":       func(r *models.FullRecord, v interface{}, _ string) { r.Location.Longitude = asFloat(v) },
+	"accuracy_radius": func(r *models.FullRecord, v interface{}, _ string) { r.Location.AccuracyRadius = uint16(asUint(v)) },
+	"time_zone":       func(r *models.FullRecord, v interface{}, _ string) { r.Location.TimeZone = asString(v) },
+	"asn":             func(r *models.FullRecord, v interface{}, _ string) { r.AutonomousSystemNumber = uint(asUint(v)) },
+	"as_organization": func(r *models.FullRecord, v interface{}, _ string) {
+		r.AutonomousSystemOrganization = asString(v)
+	},
+}
+
+// MMDBConfig configures a provider backed by any MaxMind DB format file.
+// Fields maps normalized field names to dot separated paths in the file's
+// records, with numeric segments indexing arrays (e.g. "subdivisions.0.names");
+// entries override the provider's preset mapping. Language is the locale
+// assigned to names stored as plain strings.
+type MMDBConfig struct {
+	DatabasePath string
+	Fields       map[string]string
+	Language     string
+}
+
+type mmdbField struct {
+	name string
+	path []string
+	set  fieldSetter
+}
+
+// MMDBService serves lookups from a non-MaxMind mmdb file through a field
+// mapping. The file is managed outside the service, so it cannot be updated.
+type MMDBService struct {
+	log    *logrus.Entry
+	cfg    MMDBConfig
+	db     *database
+	fields []mmdbField
+}
+
+func newMMDBFactory(name string, preset map[string]string) ProviderFactory {
+	return func(log *logrus.Entry, cfg ProviderConfig) (Provider, error) {
+		mmdbCfg := cfg.MMDB
+		if path := cfg.MMDBPaths[name]; path != "" {
+			mmdbCfg.DatabasePath = path
+		}
+
+		fields := make(map[string]string, len(preset)+len(mmdbCfg.Fields)+len(cfg.MMDBFields[name]))
+		for _, mapping := range []map[string]string{preset, mmdbCfg.Fields, cfg.MMDBFields[name]} {
+			for field, path := range mapping {
+				fields[field] = path
+			}
+		}
+		mmdbCfg.Fields = fields
+		return NewMMDBService(log.WithField("provider", name), name, mmdbCfg)
+	}
+}
+
+func NewMMDBService(log *logrus.Entry, name string, cfg MMDBConfig) (*MMDBService, error) {
+	if cfg.DatabasePath == "" {
+		return nil, errors.New("mmdb database path not configured")
+	}
+	if cfg.Language == "" {
+		cfg.Language = defaultMMDBLanguage
+	}
+
+	fields, err := parseFieldMapping(cfg.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &MMDBService{
+		log:    log,
+		cfg:    cfg,
+		db:     &database{edition: name, path: cfg.DatabasePath},
+		fields: fields,
+	}
+
+	if err := service.db.reload(); err != nil {
+		return nil, fmt.Errorf("open %s database: %w", name, err)
+	}
+
+	log.WithField("path", cfg.DatabasePath).Info("mmdb database ready")
+	return service, nil
+}
+
+func (m *MMDBService) Lookup(ip net.IP) (models.Record, error) {
+	record, err := m.LookupFull(ip)
+	if err != nil {
+		return models.Record{}, err
+	}
+	return record.Compact(), nil
+}
+
+func (m *MMDBService) LookupFull(ip net.IP) (models.FullRecord, error) {
+	var raw interface{}
+	network, err := m.db.lookup(ip, &raw)
+	if err != nil {
+		return models.FullRecord{}, err
+	}
+
+	var record models.FullRecord
+	for _, field := range m.fields {
+		if value, ok := resolvePath(raw, field.path); ok {
+			field.set(&record, value, m.cfg.Language)
+		}
+	}
+
+	record.IP = ip.String()
+	record.Network, record.PrefixLength = describeNetwork(network)
+	record.Found = true
+	record.Classification = string(utils.ClassifyIP(ip))
+	return record, nil
+}
+
+func (m *MMDBService) Update(context.Context, bool) (UpdateStatus, error) {
+	return UpdateStatus{}, ErrUpdateNotSupported
+}
+
+func (m *MMDBService) Ready() bool {
+	return m.db.ready()
+}
+
+func (m *MMDBService) DatabasePath() string {
+	return m.cfg.DatabasePath
+}
+
+func (m *MMDBService) DatabaseInfo() []DatabaseInfo {
+	if info, ok := m.db.info(); ok {
+		return []DatabaseInfo{info}
+	}
+	return nil
+}
+
+func (m *MMDBService) Close() error {
+	return m.db.close()
+}
+
+func parseFieldMapping(mapping map[string]string) ([]mmdbField, error) {
+	fields := make([]mmdbField, 0, len(mapping))
+	for name, path := range mapping {
+		set, ok := mmdbFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown mmdb field %q", name)
+		}
+
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		fields = append(fields, mmdbField{name: name, path: strings.Split(path, "."), set: set})
+	}
+	return fields, nil
+}
+
+func resolvePath(value interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+func firstSubdivision(record *models.FullRecord) *models.SubdivisionRecord {
+	if len(record.Subdivisions) == 0 {
+		record.Subdivisions = append(record.Subdivisions, models.SubdivisionRecord{})
+	}
+	return &record.Subdivisions[0]
+}
+
+func asString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// asNames accepts both localized maps and plain strings, which are taken to
+// be in lang.
+func asNames(value interface{}, lang string) map[string]string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		names := make(map[string]string, len(v))
+		for locale, name := range v {
+			names[locale] = asString(name)
+		}
+		return names
+	case string:
+		if v == "" {
+			return nil
+		}
+		return map[string]string{lang: v}
+	default:
+		return nil
+	}
+}
+
+func asFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f
+	default:
+		return float64(asUint(v))
+	}
+}
+
+// asUint also accepts strings such as "AS13335", the form IPinfo uses for
+// autonomous system numbers.
+func asUint(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case uint32:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint:
+		return uint64(v)
+	case int:
+		if v > 0 {
+			return uint64(v)
+		}
+	case string:
+		v = strings.TrimSpace(v)
+		if len(v) > 2 && strings.EqualFold(v[:2], "AS") {
+			v = v[2:]
+		}
+		n, _ := strconv.ParseUint(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func asBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	default:
+		return false
+	}
+}
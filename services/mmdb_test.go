@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/internal/mmdbtest"
+)
+
+func writeIPinfoDatabase(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ipinfo.mmdb")
+	err := mmdbtest.Write(path, mmdbtest.Options{DatabaseType: "ipinfo standard_location.mmdb"}, []mmdbtest.Network{
+		{
+			CIDR: "1.1.1.0/24",
+			Data: map[string]interface{}{
+				"city":         "Sydney",
+				"region":       "New South Wales",
+				"country_code": "AU",
+				"country":      "Australia",
+				"latitude":     "-33.8688",
+				"longitude":    "151.2093",
+				"timezone":     "Australia/Sydney",
+				"asn":          "AS13335",
+				"as_name":      "Cloudflare, Inc.",
+			},
+		},
+		{
+			CIDR: "8.8.8.0/24",
+			Data: map[string]interface{}{
+				"city":         "Mountain View",
+				"country_code": "US",
+				"country":      "United States",
+				"latitude":     "37.4056",
+				"longitude":    "-122.0775",
+				"asn":          "AS15169",
+				"as_name":      "Google LLC",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("write ipinfo database: %v", err)
+	}
+	return path
+}
+
+func newTestProvider(t *testing.T, name string, cfg MMDBConfig) Provider {
+	t.Helper()
+
+	provider, err := NewProvider(name, logrus.NewEntry(logrus.New()), ProviderConfig{MMDB: cfg})
+	if err != nil {
+		t.Fatalf("unexpected error creating %s provider: %v", name, err)
+	}
+	t.Cleanup(func() { _ = provider.Close() })
+	return provider
+}
+
+func TestIPinfoProviderNormalizesRecord(t *testing.T) {
+	provider := newTestProvider(t, ProviderIPinfo, MMDBConfig{DatabasePath: writeIPinfoDatabase(t)})
+
+	record, err := provider.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if record.Country.ISOCode != "AU" || record.Country.Names["en"] != "Australia" {
+		t.Fatalf("unexpected country: %+v", record.Country)
+	}
+	if record.City.Names["en"] != "Sydney" {
+		t.Fatalf("unexpected city: %v", record.City.Names)
+	}
+	if len(record.Subdivisions) != 1 || record.Subdivisions[0].Names["en"] != "New South Wales" {
+		t.Fatalf("unexpected subdivisions: %+v", record.Subdivisions)
+	}
+	if record.Location.Latitude != -33.8688 || record.Location.Longitude != 151.2093 {
+		t.Fatalf("expected coordinates parsed from strings, got %+v", record.Location)
+	}
+	if record.AutonomousSystemNumber != 13335 || record.AutonomousSystemOrganization != "Cloudflare, Inc." {
+		t.Fatalf("unexpected asn: %d %q", record.AutonomousSystemNumber, record.AutonomousSystemOrganization)
+	}
+	if !record.Found || record.Network != "1.1.1.0/24" || record.Classification != "public" {
+		t.Fatalf("unexpected lookup details: %+v", record)
+	}
+
+	compact, err := provider.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if compact.Country.ISOCode != "AU" || compact.City.Names["en"] != "Sydney" || compact.PrefixLength != 24 {
+		t.Fatalf("unexpected compact record: %+v", compact)
+	}
+}
+
+func TestDBIPProviderReadsGeoIP2Schema(t *testing.T) {
+	provider := newTestProvider(t, ProviderDBIP, MMDBConfig{DatabasePath: writeTestDatabase(t)})
+
+	record, err := provider.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if record.Country.ISOCode != "AU" || record.Continent.Code != "OC" || record.City.Names["en"] != "Sydney" {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+
+	if _, err := provider.Lookup(net.ParseIP("10.0.0.1")); !errors.Is(err, ErrReservedAddress) {
+		t.Fatalf("expected ErrReservedAddress, got %v", err)
+	}
+}
+
+func TestMMDBProviderFieldOverrides(t *testing.T) {
+	provider := newTestProvider(t, ProviderIPinfo, MMDBConfig{
+		DatabasePath: writeIPinfoDatabase(t),
+		Language:     "pt-BR",
+		Fields: map[string]string{
+			"city_names":        "region",
+			"subdivision_names": "",
+		},
+	})
+
+	record, err := provider.LookupFull(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if record.City.Names["pt-BR"] != "New South Wales" {
+		t.Fatalf("expected overridden city mapping in configured language, got %v", record.City.Names)
+	}
+	if len(record.Subdivisions) != 0 {
+		t.Fatalf("expected disabled subdivision mapping, got %+v", record.Subdivisions)
+	}
+}
+
+func TestMMDBProviderRejectsUnknownField(t *testing.T) {
+	_, err := NewProvider(ProviderMMDB, logrus.NewEntry(logrus.New()), ProviderConfig{
+		MMDB: MMDBConfig{
+			DatabasePath: writeIPinfoDatabase(t),
+			Fields:       map[string]string{"elevation": "alt"},
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected error for unknown field")
+	}
+}
+
+func TestMMDBProviderDoesNotUpdate(t *testing.T) {
+	provider := newTestProvider(t, ProviderMMDB, MMDBConfig{DatabasePath: writeTestDatabase(t)})
+
+	if _, err := provider.Update(context.Background(), true); !errors.Is(err, ErrUpdateNotSupported) {
+		t.Fatalf("expected ErrUpdateNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/models"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultOverlayReloadInterval = 5 * time.Second
+
+// OverlayEntry is a user-maintained location for a network. Empty fields are
+// left as resolved by MaxMind, so an entry only needs the fields it corrects.
+type OverlayEntry struct {
+	CIDR           string            `yaml:"cidr" json:"cidr"`
+	CountryISOCode string            `yaml:"country_iso_code" json:"country_iso_code"`
+	CountryNames   map[string]string `yaml:"country_names" json:"country_names"`
+	CityNames      map[string]string `yaml:"city_names" json:"city_names"`
+	PostalCode     string            `yaml:"postal_code" json:"postal_code"`
+	Latitude       *float64          `yaml:"latitude" json:"latitude"`
+	Longitude      *float64          `yaml:"longitude" json:"longitude"`
+	AccuracyRadius uint16            `yaml:"accuracy_radius" json:"accuracy_radius"`
+	TimeZone       string            `yaml:"time_zone" json:"time_zone"`
+	Metadata       map[string]string `yaml:"metadata" json:"metadata"`
+
+	network *net.IPNet
+}
+
+type overlayFile struct {
+	Networks []OverlayEntry `yaml:"networks" json:"networks"`
+}
+
+// Overlay resolves addresses from a YAML or JSON file of CIDR entries using
+// longest-prefix matching. The file is polled and reloaded when its size or
+// modification time changes; a file that fails to parse keeps the previous
+// entries in place.
+type Overlay struct {
+	path     string
+	interval time.Duration
+	log      *logrus.Entry
+	// onChange, when set, runs after new entries are installed
+	onChange func()
+
+	entries atomic.Pointer[[]OverlayEntry]
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewOverlay loads the file at path and polls it every interval. onChange,
+// which may be nil, runs after each reload that installs new entries.
+func NewOverlay(log *logrus.Entry, path string, interval time.Duration, onChange func()) (*Overlay, error) {
+	if interval <= 0 {
+		interval = defaultOverlayReloadInterval
+	}
+
+	overlay := &Overlay{
+		path:     path,
+		interval: interval,
+		log:      log,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if _, err := overlay.Reload(); err != nil {
+		return nil, err
+	}
+
+	go overlay.watch()
+	return overlay, nil
+}
+
+// Lookup returns the most specific entry containing ip.
+func (o *Overlay) Lookup(ip net.IP) (OverlayEntry, bool) {
+	entries := o.entries.Load()
+	if entries == nil || ip == nil {
+		return OverlayEntry{}, false
+	}
+
+	for _, entry := range *entries {
+		if entry.network.Contains(ip) {
+			return entry, true
+		}
+	}
+	return OverlayEntry{}, false
+}
+
+// hasNestedEntry reports whether an entry more specific than network lies
+// inside it, i.e. whether addresses of network may resolve differently.
+func (o *Overlay) hasNestedEntry(network *net.IPNet) bool {
+	entries := o.entries.Load()
+	if entries == nil {
+		return false
+	}
+
+	ones, _ := network.Mask.Size()
+	for _, entry := range *entries {
+		entryOnes, _ := entry.network.Mask.Size()
+		if entryOnes > ones && network.Contains(entry.network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload re-reads the file when it changed since the last load and reports
+// whether new entries were installed.
+func (o *Overlay) Reload() (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info, err := os.Stat(o.path)
+	if err != nil {
+		return false, err
+	}
+
+	if o.entries.Load() != nil && info.ModTime().Equal(o.modTime) && info.Size() == o.size {
+		return false, nil
+	}
+
+	entries, err := loadOverlayEntries(o.path)
+	if err != nil {
+		return false, err
+	}
+
+	o.entries.Store(&entries)
+	o.modTime = info.ModTime()
+	o.size = info.Size()
+
+	if o.onChange != nil {
+		o.onChange()
+	}
+	return true, nil
+}
+
+func (o *Overlay) Close() {
+	o.stopOnce.Do(func() {
+		close(o.stop)
+		<-o.done
+	})
+}
+
+func (o *Overlay) watch() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			reloaded, err := o.Reload()
+			if err != nil {
+				o.log.WithError(err).WithField("path", o.path).Warn("could not reload overlay, keeping previous entries")
+				continue
+			}
+			if reloaded {
+				o.log.WithField("path", o.path).WithField("entries", len(*o.entries.Load())).Info("overlay reloaded")
+			}
+		}
+	}
+}
+
+func loadOverlayEntries(path string) ([]OverlayEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file overlayFile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse overlay %s: %w", path, err)
+	}
+
+	entries := make([]OverlayEntry, 0, len(file.Networks))
+	for _, entry := range file.Networks {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(entry.CIDR))
+		if err != nil {
+			return nil, fmt.Errorf("parse overlay %s: %w", path, err)
+		}
+		entry.network = network
+		entries = append(entries, entry)
+	}
+
+	// most specific first, so the first match is the longest prefix
+	sort.SliceStable(entries, func(i, j int) bool {
+		onesI, _ := entries[i].network.Mask.Size()
+		onesJ, _ := entries[j].network.Mask.Size()
+		return onesI > onesJ
+	})
+
+	return entries, nil
+}
+
+func (e OverlayEntry) applyFullRecord(record *models.FullRecord) {
+	if e.CountryISOCode != "" {
+		record.Country.ISOCode = e.CountryISOCode
+	}
+	if len(e.CountryNames) > 0 {
+		record.Country.Names = e.CountryNames
+	}
+	if len(e.CityNames) > 0 {
+		record.City.Names = e.CityNames
+	}
+	if e.PostalCode != "" {
+		record.Postal.Code = e.PostalCode
+	}
+	if e.Latitude != nil {
+		record.Location.Latitude = *e.Latitude
+	}
+	if e.Longitude != nil {
+		record.Location.Longitude = *e.Longitude
+	}
+	if e.AccuracyRadius != 0 {
+		record.Location.AccuracyRadius = e.AccuracyRadius
+	}
+	if e.TimeZone != "" {
+		record.Location.TimeZone = e.TimeZone
+	}
+	if len(e.Metadata) > 0 {
+		record.Metadata = e.Metadata
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testOverlayYAML = `networks:
+  - cidr: 10.0.0.0/8
+    country_iso_code: BR
+    city_names: {en: Sao Paulo}
+    metadata: {site: datacenter}
+  - cidr: 10.1.0.0/16
+    city_names: {en: Campinas}
+    metadata: {site: office}
+  - cidr: 1.1.1.128/25
+    city_names: {en: Melbourne}
+    latitude: -37.81
+    longitude: 144.96
+`
+
+func writeOverlay(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write overlay: %v", err)
+	}
+	return path
+}
+
+func newTestOverlay(t *testing.T, path string) *Overlay {
+	t.Helper()
+
+	overlay, err := NewOverlay(logrus.NewEntry(logrus.New()), path, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("unexpected error loading overlay: %v", err)
+	}
+	t.Cleanup(overlay.Close)
+	return overlay
+}
+
+func TestOverlayLongestPrefixMatch(t *testing.T) {
+	overlay := newTestOverlay(t, writeOverlay(t, "overlay.yaml", testOverlayYAML))
+
+	tests := []struct {
+		ip   string
+		city string
+		ok   bool
+	}{
+		{ip: "10.1.2.3", city: "Campinas", ok: true},
+		{ip: "10.2.2.3", city: "Sao Paulo", ok: true},
+		{ip: "1.1.1.200", city: "Melbourne", ok: true},
+		{ip: "1.1.1.1", ok: false},
+	}
+
+	for _, tt := range tests {
+		entry, ok := overlay.Lookup(net.ParseIP(tt.ip))
+		if ok != tt.ok {
+			t.Fatalf("%s: expected match %v, got %v", tt.ip, tt.ok, ok)
+		}
+		if ok && entry.CityNames["en"] != tt.city {
+			t.Fatalf("%s: expected city %q, got %q", tt.ip, tt.city, entry.CityNames["en"])
+		}
+	}
+}
+
+func TestOverlayLoadsJSON(t *testing.T) {
+	path := writeOverlay(t, "overlay.json", `{"networks": [{"cidr": "192.168.0.0/16", "country_iso_code": "PT", "metadata": {"site": "lab"}}]}`)
+	overlay := newTestOverlay(t, path)
+
+	entry, ok := overlay.Lookup(net.ParseIP("192.168.10.1"))
+	if !ok {
+		t.Fatalf("expected match")
+	}
+	if entry.CountryISOCode != "PT" || entry.Metadata["site"] != "lab" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestOverlayRejectsInvalidCIDR(t *testing.T) {
+	path := writeOverlay(t, "overlay.yaml", "networks:\n  - cidr: not-a-network\n")
+
+	if _, err := NewOverlay(logrus.NewEntry(logrus.New()), path, time.Hour, nil); err == nil {
+		t.Fatalf("expected error for invalid cidr")
+	}
+}
+
+func TestOverlayReloadsChangedFile(t *testing.T) {
+	path := writeOverlay(t, "overlay.yaml", testOverlayYAML)
+	overlay := newTestOverlay(t, path)
+
+	reloaded, err := overlay.Reload()
+	if err != nil || reloaded {
+		t.Fatalf("expected unchanged file to be skipped, got reloaded=%v err=%v", reloaded, err)
+	}
+
+	if err := os.WriteFile(path, []byte("networks:\n  - cidr: 10.0.0.0/8\n    city_names: {en: Recife}\n"), 0o644); err != nil {
+		t.Fatalf("rewrite overlay: %v", err)
+	}
+
+	reloaded, err = overlay.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("expected reload, got reloaded=%v err=%v", reloaded, err)
+	}
+
+	entry, ok := overlay.Lookup(net.ParseIP("10.1.2.3"))
+	if !ok || entry.CityNames["en"] != "Recife" {
+		t.Fatalf("expected reloaded entry, got %+v (ok=%v)", entry, ok)
+	}
+
+	if err := os.WriteFile(path, []byte("networks: [broken"), 0o644); err != nil {
+		t.Fatalf("rewrite overlay: %v", err)
+	}
+	if _, err := overlay.Reload(); err == nil {
+		t.Fatalf("expected parse error")
+	}
+	if entry, ok := overlay.Lookup(net.ParseIP("10.1.2.3")); !ok || entry.CityNames["en"] != "Recife" {
+		t.Fatalf("expected previous entries to stay active, got %+v (ok=%v)", entry, ok)
+	}
+}
+
+func newOverlayService(t *testing.T) *MaxMindService {
+	t.Helper()
+
+	cfg := MaxMindConfig{
+		DatabasePath: writeTestDatabase(t),
+		OverlayPath:  writeOverlay(t, "overlay.yaml", testOverlayYAML),
+	}
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error creating service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc
+}
+
+func TestMaxMindServiceLookupMergesOverlay(t *testing.T) {
+	svc := newOverlayService(t)
+
+	record, err := svc.Lookup(net.ParseIP("1.1.1.200"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if record.City.Names["en"] != "Melbourne" {
+		t.Fatalf("expected overlay city, got %v", record.City.Names)
+	}
+	if record.Country.ISOCode != "AU" {
+		t.Fatalf("expected country from maxmind, got %q", record.Country.ISOCode)
+	}
+	if record.Location.Latitude != -37.81 || record.Location.Longitude != 144.96 {
+		t.Fatalf("expected overlay coordinates, got %+v", record.Location)
+	}
+	if record.Network != "1.1.1.128/25" || record.PrefixLength != 25 {
+		t.Fatalf("expected the narrower overlay network, got %s/%d", record.Network, record.PrefixLength)
+	}
+
+	full, err := svc.LookupFull(net.ParseIP("1.1.1.200"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if full.City.Names["en"] != "Melbourne" || full.Continent.Code != "OC" {
+		t.Fatalf("unexpected full record: %+v", full)
+	}
+}
+
+func TestMaxMindServiceLookupOverlayOnly(t *testing.T) {
+	svc := newOverlayService(t)
+
+	record, err := svc.Lookup(net.ParseIP("10.1.2.3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !record.Found || record.Network != "10.1.0.0/16" || record.PrefixLength != 16 {
+		t.Fatalf("unexpected network: %+v", record)
+	}
+	if record.City.Names["en"] != "Campinas" || record.Metadata["site"] != "office" {
+		t.Fatalf("unexpected overlay fields: %+v", record)
+	}
+	if record.Classification != "private" {
+		t.Fatalf("expected private classification, got %q", record.Classification)
+	}
+
+	if _, err := svc.Lookup(net.ParseIP("172.16.0.1")); !errors.Is(err, ErrReservedAddress) {
+		t.Fatalf("expected ErrReservedAddress outside the overlay, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/models"
+)
+
+const (
+	ProviderMaxMind = "maxmind"
+	ProviderMMDB    = "mmdb"
+	ProviderDBIP    = "dbip"
+	ProviderIPinfo  = "ipinfo"
+)
+
+// Provider is a source of geolocation records. Every provider returns the
+// normalized models.Record and models.FullRecord, whatever the schema of the
+// data behind it.
+type Provider interface {
+	Lookup(net.IP) (models.Record, error)
+	LookupFull(net.IP) (models.FullRecord, error)
+	Update(context.Context, bool) (UpdateStatus, error)
+	Ready() bool
+	DatabasePath() string
+	Close() error
+}
+
+// ProviderConfig carries the settings of every built-in provider; each
+// factory reads the part it needs. MMDBPaths and MMDBFields set the file and
+// field overrides of a single mmdb-backed provider by name, so several can be
+// chained at once; the path and fields in MMDB are shared by all of them.
+type ProviderConfig struct {
+	MaxMind    MaxMindConfig
+	MMDB       MMDBConfig
+	MMDBPaths  map[string]string
+	MMDBFields map[string]map[string]string
+}
+
+type ProviderFactory func(log *logrus.Entry, cfg ProviderConfig) (Provider, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderFactory{}
+)
+
+func init() {
+	RegisterProvider(ProviderMaxMind, func(log *logrus.Entry, cfg ProviderConfig) (Provider, error) {
+		return NewMaxMindService(log, cfg.MaxMind)
+	})
+	RegisterProvider(ProviderMMDB, newMMDBFactory(ProviderMMDB, GeoIP2FieldMapping))
+	RegisterProvider(ProviderDBIP, newMMDBFactory(ProviderDBIP, GeoIP2FieldMapping))
+	RegisterProvider(ProviderIPinfo, newMMDBFactory(ProviderIPinfo, IPinfoFieldMapping))
+}
+
+// mmdbProviders are the built-in providers that read cfg.MMDB.
+var mmdbProviders = map[string]bool{
+	ProviderMMDB:   true,
+	ProviderDBIP:   true,
+	ProviderIPinfo: true,
+}
+
+// RegisterProvider makes a provider available to NewProvider under name,
+// replacing any provider previously registered with the same name.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[strings.ToLower(name)] = factory
+}
+
+// NewProvider builds the provider registered under name. An empty name
+// selects MaxMind.
+func NewProvider(name string, log *logrus.Entry, cfg ProviderConfig) (Provider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = ProviderMaxMind
+	}
+
+	providersMu.RLock()
+	factory, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q (available: %s)", ErrUnknownProvider, name, strings.Join(ProviderNames(), ", "))
+	}
+
+	return factory(log, cfg)
+}
+
+// ProviderNames lists the registered providers in alphabetical order.
+func ProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
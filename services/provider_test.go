@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewProviderDefaultsToMaxMind(t *testing.T) {
+	provider, err := NewProvider("", logrus.NewEntry(logrus.New()), ProviderConfig{
+		MaxMind: MaxMindConfig{DatabasePath: writeTestDatabase(t)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer provider.Close()
+
+	if _, ok := provider.(*MaxMindService); !ok {
+		t.Fatalf("expected *MaxMindService, got %T", provider)
+	}
+}
+
+func TestNewProviderUnknown(t *testing.T) {
+	_, err := NewProvider("nope", logrus.NewEntry(logrus.New()), ProviderConfig{})
+	if !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("expected ErrUnknownProvider, got %v", err)
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	want := &MMDBService{}
+	RegisterProvider("Custom", func(*logrus.Entry, ProviderConfig) (Provider, error) {
+		return want, nil
+	})
+	t.Cleanup(func() {
+		providersMu.Lock()
+		delete(providers, "custom")
+		providersMu.Unlock()
+	})
+
+	got, err := NewProvider("custom", logrus.NewEntry(logrus.New()), ProviderConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("expected registered provider, got %v", got)
+	}
+}
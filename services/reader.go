@@ -0,0 +1,225 @@
+package services
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/thiagozs/geolocation-go/pkg/metrics"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
+)
+
+// readerHandle is one generation of an opened database. The owning database
+// holds a reference while the handle is current and every lookup takes its
+// own, so the reader is only closed once it has been swapped out and the last
+// lookup still decoding from it has returned.
+type readerHandle struct {
+	reader     *maxminddb.Reader
+	generation uint64
+	refs       atomic.Int64
+}
+
+func newReaderHandle(reader *maxminddb.Reader, generation uint64) *readerHandle {
+	handle := &readerHandle{
+		reader:     reader,
+		generation: generation,
+	}
+	handle.refs.Store(1)
+	return handle
+}
+
+func (h *readerHandle) acquire() {
+	h.refs.Add(1)
+}
+
+func (h *readerHandle) release() error {
+	if h.refs.Add(-1) != 0 {
+		return nil
+	}
+	return h.reader.Close()
+}
+
+// database is a single mmdb file, usually a MaxMind edition: where it lives on disk, how it is
+// downloaded and which reader generation is currently serving lookups.
+type database struct {
+	edition    string
+	path       string
+	downloader *utils.DatabaseDownloader
+	// generations, when set, keeps previous versions of the file for rollback
+	generations *utils.GenerationStore
+	// onSwap, when set, runs after a new reader generation is installed
+	onSwap func()
+
+	mu         sync.RWMutex
+	current    *readerHandle
+	generation uint64
+	// loaded is the file behind current, to tell when the path changes
+	loaded   os.FileInfo
+	loadedAt time.Time
+}
+
+// DatabaseInfo describes a loaded database: the mmdb metadata, the file it
+// was read from and when. Checksum is the content of the .sha256 file the
+// downloader keeps next to it, if any.
+type DatabaseInfo struct {
+	EditionID    string            `json:"edition_id"`
+	Path         string            `json:"path"`
+	Size         int64             `json:"size"`
+	Checksum     string            `json:"checksum,omitempty"`
+	LoadedAt     time.Time         `json:"loaded_at"`
+	DatabaseType string            `json:"database_type"`
+	BuildEpoch   uint              `json:"build_epoch"`
+	BuildTime    time.Time         `json:"build_time"`
+	IPVersion    uint              `json:"ip_version"`
+	Languages    []string          `json:"languages"`
+	NodeCount    uint              `json:"node_count"`
+	RecordSize   uint              `json:"record_size"`
+	Description  map[string]string `json:"description"`
+}
+
+// acquire returns the current handle with an extra reference taken, or nil
+// when no reader is loaded. Callers must release the handle when done.
+func (d *database) acquire() *readerHandle {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.current == nil {
+		return nil
+	}
+	d.current.acquire()
+	return d.current
+}
+
+// reload opens the database file and swaps it in as a new generation. The
+// previous reader is closed once in-flight lookups have released it.
+func (d *database) reload() error {
+	reader, err := maxminddb.Open(d.path)
+	if err != nil {
+		return err
+	}
+	info, _ := os.Stat(d.path)
+
+	d.mu.Lock()
+	d.loaded = info
+	d.loadedAt = time.Now()
+	d.generation++
+	oldHandle := d.current
+	d.current = newReaderHandle(reader, d.generation)
+	d.mu.Unlock()
+
+	metrics.SetDatabaseBuild(d.edition, time.Unix(int64(reader.Metadata.BuildEpoch), 0))
+	if d.onSwap != nil {
+		d.onSwap()
+	}
+
+	if oldHandle != nil {
+		_ = oldHandle.release()
+	}
+	return nil
+}
+
+// lookup decodes the record for ip into result and returns the network the
+// record belongs to. Addresses without an entry yield a *LookupError wrapping
+// ErrReservedAddress or ErrAddressNotFound, along with the network without
+// data.
+func (d *database) lookup(ip net.IP, result interface{}) (*net.IPNet, error) {
+	if ip == nil {
+		return nil, ErrInvalidAddress
+	}
+
+	handle := d.acquire()
+	if handle == nil {
+		return nil, ErrDatabaseMissing
+	}
+	defer handle.release()
+
+	if handle.reader.Metadata.IPVersion == 4 && ip.To4() == nil {
+		return nil, &LookupError{
+			IP:             ip.String(),
+			Classification: string(utils.ClassifyIP(ip)),
+			Err:            ErrUnsupportedIPVersion,
+		}
+	}
+
+	network, found, err := handle.reader.LookupNetwork(ip, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		lookupErr := &LookupError{
+			IP:             ip.String(),
+			Classification: string(utils.ClassifyIP(ip)),
+			Err:            ErrAddressNotFound,
+		}
+		lookupErr.Network, lookupErr.PrefixLength = describeNetwork(network)
+		if !utils.IsGloballyReachable(ip) {
+			lookupErr.Err = ErrReservedAddress
+		}
+		return network, lookupErr
+	}
+
+	return network, nil
+}
+
+// changed reports whether info describes a different file from the one
+// loaded, or the same file rewritten.
+func (d *database) changed(info os.FileInfo) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.loaded == nil || !sameFile(d.loaded, info)
+}
+
+// info describes the loaded reader, or reports false when none is loaded.
+func (d *database) info() (DatabaseInfo, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.current == nil {
+		return DatabaseInfo{}, false
+	}
+
+	meta := d.current.reader.Metadata
+	info := DatabaseInfo{
+		EditionID:    d.edition,
+		Path:         d.path,
+		LoadedAt:     d.loadedAt,
+		DatabaseType: meta.DatabaseType,
+		BuildEpoch:   meta.BuildEpoch,
+		BuildTime:    time.Unix(int64(meta.BuildEpoch), 0).UTC(),
+		IPVersion:    meta.IPVersion,
+		Languages:    meta.Languages,
+		NodeCount:    meta.NodeCount,
+		RecordSize:   meta.RecordSize,
+		Description:  meta.Description,
+	}
+	if d.loaded != nil {
+		info.Size = d.loaded.Size()
+	}
+	if checksum, err := os.ReadFile(d.path + utils.DefaultChecksumExt); err == nil {
+		info.Checksum = strings.TrimSpace(string(checksum))
+	}
+	return info, true
+}
+
+func (d *database) ready() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.current != nil
+}
+
+func (d *database) close() error {
+	d.mu.Lock()
+	handle := d.current
+	d.current = nil
+	d.mu.Unlock()
+
+	if handle == nil {
+		return nil
+	}
+	return handle.release()
+}
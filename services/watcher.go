@@ -0,0 +1,188 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/pkg/utils"
+)
+
+const defaultWatchDebounce = 2 * time.Second
+
+// databaseWatcher reloads databases whose files are replaced by someone else,
+// such as geoipupdate or a mounted ConfigMap. It watches the directories
+// rather than the files so that renames over the file and Kubernetes' swap of
+// the "..data" symlink are both seen.
+type databaseWatcher struct {
+	service  *MaxMindService
+	debounce time.Duration
+	log      *logrus.Entry
+	watcher  *fsnotify.Watcher
+
+	mu      sync.Mutex
+	pending map[*database]bool
+	// rejected holds the file that last failed validation per database, so
+	// it is not validated again until it changes
+	rejected map[*database]os.FileInfo
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newDatabaseWatcher(service *MaxMindService, debounce time.Duration) (*databaseWatcher, error) {
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &databaseWatcher{
+		service:  service,
+		debounce: debounce,
+		log:      service.log.WithField("component", "watcher"),
+		watcher:  watcher,
+		pending:  map[*database]bool{},
+		rejected: map[*database]os.FileInfo{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	dirs := map[string]bool{}
+	for _, db := range service.databases {
+		dir := filepath.Dir(db.path)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		dirs[dir] = true
+	}
+
+	go w.watch()
+	return w, nil
+}
+
+func (w *databaseWatcher) Close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		w.watcher.Close()
+	})
+}
+
+func (w *databaseWatcher) watch() {
+	defer close(w.done)
+
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if w.queue(event) {
+				timer.Reset(w.debounce)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.log.WithError(err).Warn("database watcher error")
+		case <-timer.C:
+			w.mu.Lock()
+			pending := w.pending
+			w.pending = map[*database]bool{}
+			w.mu.Unlock()
+
+			for db := range pending {
+				w.check(db)
+			}
+		}
+	}
+}
+
+// queue marks the databases an event may concern and reports whether any
+// did. Besides the file itself, Kubernetes volume updates show up as events
+// on the "..data" entries next to it.
+func (w *databaseWatcher) queue(event fsnotify.Event) bool {
+	dir, name := filepath.Split(event.Name)
+	dir = filepath.Clean(dir)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queued := false
+	for _, db := range w.service.databases {
+		if filepath.Dir(db.path) != dir {
+			continue
+		}
+		if name == filepath.Base(db.path) || strings.HasPrefix(name, "..") {
+			w.pending[db] = true
+			queued = true
+		}
+	}
+	return queued
+}
+
+// check reloads db when its file is no longer the one loaded and the new file
+// passes validation. Going back to an older build is allowed here, since the
+// file was put in place on purpose.
+func (w *databaseWatcher) check(db *database) {
+	w.service.updateMu.Lock()
+	defer w.service.updateMu.Unlock()
+
+	log := w.log.WithField("edition", db.edition).WithField("path", db.path)
+
+	info, err := os.Stat(db.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).Warn("could not stat database")
+		}
+		return
+	}
+	if !db.changed(info) {
+		return
+	}
+	if rejected := w.rejected[db]; rejected != nil && sameFile(rejected, info) {
+		return
+	}
+
+	databaseType := db.edition
+	if handle := db.acquire(); handle != nil {
+		databaseType = handle.reader.Metadata.DatabaseType
+		_ = handle.release()
+	}
+
+	if err := utils.ValidateDatabase(db.path, databaseType, 0, utils.DefaultCanaryIPs); err != nil {
+		w.rejected[db] = info
+		log.WithError(err).Warn("database changed on disk but is not valid, keeping the loaded one")
+		return
+	}
+	delete(w.rejected, db)
+
+	if err := db.reload(); err != nil {
+		log.WithError(err).Warn("could not reload database")
+		return
+	}
+	log.Info("database changed on disk, reloaded")
+}
+
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thiagozs/geolocation-go/internal/mmdbtest"
+)
+
+func writeCountryDatabase(t *testing.T, path, isoCode string) {
+	t.Helper()
+
+	err := mmdbtest.Write(path, mmdbtest.Options{BuildEpoch: 1700000000}, []mmdbtest.Network{
+		{CIDR: "1.1.1.0/24", Data: map[string]interface{}{"country": map[string]interface{}{"iso_code": isoCode}}},
+	})
+	if err != nil {
+		t.Fatalf("write test database: %v", err)
+	}
+}
+
+func newWatchedService(t *testing.T, dbPath string) *MaxMindService {
+	t.Helper()
+
+	svc, err := NewMaxMindService(logrus.NewEntry(logrus.New()), MaxMindConfig{
+		DatabasePath:  dbPath,
+		CacheSize:     -1,
+		WatchDatabase: true,
+		WatchDebounce: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc
+}
+
+func waitForCountry(t *testing.T, svc *MaxMindService, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		record, err := svc.Lookup(net.ParseIP("1.1.1.1"))
+		if err == nil && record.Country.ISOCode == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected country %s after reload, got %+v, %v", want, record, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatcherReloadsReplacedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "GeoLite2-City.mmdb")
+	writeCountryDatabase(t, dbPath, "AU")
+
+	svc := newWatchedService(t, dbPath)
+	waitForCountry(t, svc, "AU")
+
+	// the way geoipupdate installs a file: write elsewhere, rename over
+	tmpPath := filepath.Join(dir, "GeoLite2-City.mmdb.tmp")
+	writeCountryDatabase(t, tmpPath, "NZ")
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForCountry(t, svc, "NZ")
+}
+
+func TestWatcherFollowsKubernetesSymlinkSwap(t *testing.T) {
+	dir := t.TempDir()
+
+	// the layout of a mounted ConfigMap or Secret volume
+	first := filepath.Join(dir, "..2024_01_01_00_00_00.000000001")
+	if err := os.Mkdir(first, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeCountryDatabase(t, filepath.Join(first, "GeoLite2-City.mmdb"), "AU")
+	if err := os.Symlink(filepath.Base(first), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "GeoLite2-City.mmdb")
+	if err := os.Symlink(filepath.Join("..data", "GeoLite2-City.mmdb"), dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newWatchedService(t, dbPath)
+	waitForCountry(t, svc, "AU")
+
+	second := filepath.Join(dir, "..2024_01_02_00_00_00.000000002")
+	if err := os.Mkdir(second, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeCountryDatabase(t, filepath.Join(second, "GeoLite2-City.mmdb"), "NZ")
+	if err := os.Symlink(filepath.Base(second), filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForCountry(t, svc, "NZ")
+}
+
+func TestWatcherKeepsDatabaseWhenReplacementIsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "GeoLite2-City.mmdb")
+	writeCountryDatabase(t, dbPath, "AU")
+
+	svc := newWatchedService(t, dbPath)
+	generation := svc.primary.acquire()
+	want := generation.generation
+	_ = generation.release()
+
+	tmpPath := filepath.Join(dir, "GeoLite2-City.mmdb.tmp")
+	if err := os.WriteFile(tmpPath, []byte("truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	handle := svc.primary.acquire()
+	defer handle.release()
+	if handle.generation != want {
+		t.Fatalf("expected the invalid file not to be loaded")
+	}
+	waitForCountry(t, svc, "AU")
+
+	writeCountryDatabase(t, tmpPath, "NZ")
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	waitForCountry(t, svc, "NZ")
+}